@@ -0,0 +1,258 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object/commitgraph"
+)
+
+// AgeSource names the date of a branch that age filters compare.
+type AgeSource string
+
+const (
+	// AgeFromCommit dates a branch by the committer date of its head.
+	AgeFromCommit AgeSource = "commit"
+	// AgeFromMerge dates a branch by the committer date of the first commit on
+	// master's first-parent history that contains its head, which is when the
+	// branch was merged. Branches whose head is not part of master, such as
+	// squash-merged ones, fall back to the date of their head.
+	AgeFromMerge AgeSource = "merge"
+)
+
+// ageUnits are the units an age may be given in.
+var ageUnits = map[string]time.Duration{
+	"h":  time.Hour,
+	"d":  24 * time.Hour,
+	"w":  7 * 24 * time.Hour,
+	"mo": 30 * 24 * time.Hour,
+	"y":  365 * 24 * time.Hour,
+}
+
+var ageRegexp = regexp.MustCompile(`^(\d+)(h|d|w|mo|y)$`)
+
+// ageMonthTypo matches an age in minutes, which is much more likely a month
+// missing its "o".
+var ageMonthTypo = regexp.MustCompile(`^\d+m$`)
+
+// ageDateLayouts are the layouts absolute dates may be given in, in local time
+// unless they carry a zone.
+var ageDateLayouts = []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04", "2006-01-02"}
+
+// AgeFilter keeps the branches dated within its bounds. Zero bounds do not
+// filter, so the zero AgeFilter keeps every branch.
+type AgeFilter struct {
+	// OlderThan keeps only branches dated before it.
+	OlderThan time.Time
+	// NewerThan keeps only branches dated after it.
+	NewerThan time.Time
+	// From is the date compared, AgeFromCommit when empty.
+	From AgeSource
+}
+
+// active tells whether the filter has any bound.
+func (f AgeFilter) active() bool {
+	return !f.OlderThan.IsZero() || !f.NewerThan.IsZero()
+}
+
+// ParseAgeSource parses the value of --age-from.
+func ParseAgeSource(s string) (AgeSource, error) {
+	switch source := AgeSource(strings.ToLower(strings.TrimSpace(s))); source {
+	case AgeFromCommit, AgeFromMerge:
+		return source, nil
+	case "":
+		return AgeFromCommit, nil
+	}
+	return "", fmt.Errorf("unknown age source %q, use commit or merge", s)
+}
+
+// ParseAgeCutoff parses the value of --older-than or --newer-than into the time
+// it refers to: an age such as "36h", "14d", "2w", "6mo" or "1y" counts back from
+// now, while a date such as "2024-01-31" or an RFC 3339 time is taken as it is.
+// Other units, such as minutes, are refused, so that "6m" is not taken as six
+// minutes when six months were meant.
+func ParseAgeCutoff(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+
+	if match := ageRegexp.FindStringSubmatch(s); match != nil {
+		n, err := strconv.Atoi(match[1])
+		if err == nil {
+			return now.Add(-time.Duration(n) * ageUnits[match[2]]), nil
+		}
+	}
+
+	for _, layout := range ageDateLayouts {
+		if date, err := time.ParseInLocation(layout, s, time.Local); err == nil {
+			return date, nil
+		}
+	}
+
+	if ageMonthTypo.MatchString(s) {
+		return time.Time{}, fmt.Errorf("invalid age %q, months are written mo, such as %so", s, s)
+	}
+	return time.Time{}, fmt.Errorf("invalid age %q, use an age such as 14d, 2w or 36h, or a date such as 2024-01-31", s)
+}
+
+// branchAges dates branches to apply an AgeFilter.
+type branchAges struct {
+	filter  AgeFilter
+	index   commitgraph.CommitNodeIndex
+	closer  io.Closer
+	masters []*masterHistory
+}
+
+// masterHistory is the history of a master, for finding when a commit became
+// part of it.
+type masterHistory struct {
+	// merged maps every commit of the master to the committer date of the oldest
+	// commit of the first-parent history that contains it.
+	merged map[plumbing.Hash]time.Time
+}
+
+// newBranchAges returns the dater for the filter, or nil when the filter keeps
+// every branch. Masters are only needed when dating by merge.
+func newBranchAges(
+	ctx context.Context,
+	repo *git.Repository,
+	filter AgeFilter,
+	masterHashes map[string]plumbing.Hash,
+) (*branchAges, error) {
+	if !filter.active() {
+		return nil, nil
+	}
+
+	index, closer := openCommitNodeIndex(repo)
+	ages := &branchAges{filter: filter, index: index, closer: closer}
+	if filter.From != AgeFromMerge {
+		return ages, nil
+	}
+
+	for _, hash := range masterHashes {
+		history, err := newMasterHistory(ctx, index, hash)
+		if err != nil {
+			_ = closer.Close()
+			return nil, err
+		}
+		ages.masters = append(ages.masters, history)
+	}
+
+	return ages, nil
+}
+
+// newMasterHistory dates every commit of the master at hash. Its first-parent
+// history is walked from the oldest commit, each one merging the commits it
+// reaches that no older one did, so the whole history is walked only once.
+func newMasterHistory(ctx context.Context, index commitgraph.CommitNodeIndex, hash plumbing.Hash) (*masterHistory, error) {
+	// chain lists the first-parent history from the tip, newest first
+	var chain []commitgraph.CommitNode
+	node, err := index.Get(hash)
+	for err == nil {
+		chain = append(chain, node)
+		if node.NumParents() == 0 {
+			break
+		}
+		node, err = node.ParentNode(0)
+	}
+	// A shallow history just ends early
+	if err != nil && !errors.Is(err, plumbing.ErrObjectNotFound) {
+		return nil, fmt.Errorf("walking the history of master %s failed: %w", hash, err)
+	}
+
+	m := &masterHistory{merged: make(map[plumbing.Hash]time.Time)}
+	for i := len(chain) - 1; i >= 0; i-- {
+		date := chain[i].CommitTime()
+		m.merged[chain[i].ID()] = date
+
+		pending := []commitgraph.CommitNode{chain[i]}
+		for len(pending) > 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			current := pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+
+			for j, parentHash := range current.ParentHashes() {
+				if _, ok := m.merged[parentHash]; ok {
+					continue
+				}
+				m.merged[parentHash] = date
+
+				parent, err := current.ParentNode(j)
+				if errors.Is(err, plumbing.ErrObjectNotFound) {
+					continue
+				}
+				if err != nil {
+					return nil, fmt.Errorf("loading parent %s of %s failed: %w", parentHash, current.ID(), err)
+				}
+				pending = append(pending, parent)
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// Close releases the commit-graph files, if any were opened.
+func (a *branchAges) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.closer.Close()
+}
+
+// keep tells whether the branch is within the bounds of the filter. A nil
+// branchAges keeps every branch.
+func (a *branchAges) keep(branch BranchInfo) (bool, error) {
+	if a == nil {
+		return true, nil
+	}
+
+	date, err := a.date(branch.Hash)
+	if err != nil {
+		return false, fmt.Errorf("dating branch %s failed: %w", branch.Name, err)
+	}
+
+	switch {
+	case !a.filter.OlderThan.IsZero() && !date.Before(a.filter.OlderThan):
+		LogInfof("Branch '%s' dated %s is not older than %s", branch.Name, date.Format(time.RFC3339),
+			a.filter.OlderThan.Format(time.RFC3339))
+		return false, nil
+	case !a.filter.NewerThan.IsZero() && !date.After(a.filter.NewerThan):
+		LogInfof("Branch '%s' dated %s is not newer than %s", branch.Name, date.Format(time.RFC3339),
+			a.filter.NewerThan.Format(time.RFC3339))
+		return false, nil
+	}
+	return true, nil
+}
+
+// date returns the date of the branch head hash the filter compares.
+func (a *branchAges) date(hash plumbing.Hash) (time.Time, error) {
+	node, err := a.index.Get(hash)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("loading commit %s failed: %w", hash, err)
+	}
+	date := node.CommitTime()
+
+	// The earliest merge into any of the masters counts
+	var merged time.Time
+	for _, master := range a.masters {
+		mergeDate, ok := master.merged[hash]
+		if ok && (merged.IsZero() || mergeDate.Before(merged)) {
+			merged = mergeDate
+		}
+	}
+
+	if !merged.IsZero() {
+		return merged, nil
+	}
+	return date, nil
+}
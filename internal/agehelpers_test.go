@@ -0,0 +1,144 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// merge commits a merge of other into the current branch.
+func (r *testRepo) merge(msg string, other plumbing.Hash) plumbing.Hash {
+	r.t.Helper()
+
+	head, err := r.repo.Head()
+	require.NoError(r.t, err)
+
+	r.clock = r.clock.Add(r.step)
+	signature := &object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: r.clock}
+	hash, err := r.wt.Commit(msg, &git.CommitOptions{
+		Author:            signature,
+		Committer:         signature,
+		Parents:           []plumbing.Hash{head.Hash(), other},
+		AllowEmptyCommits: true,
+	})
+	require.NoError(r.t, err)
+
+	return hash
+}
+
+func TestParseAgeCutoff(t *testing.T) {
+	now := time.Date(2024, 6, 15, 12, 0, 0, 0, time.UTC)
+
+	for input, expected := range map[string]time.Time{
+		"14d":                  now.AddDate(0, 0, -14),
+		"2w":                   now.AddDate(0, 0, -14),
+		"36h":                  now.Add(-36 * time.Hour),
+		"6mo":                  now.Add(-180 * 24 * time.Hour),
+		"1y":                   now.Add(-365 * 24 * time.Hour),
+		"2024-01-31T10:00:00Z": time.Date(2024, 1, 31, 10, 0, 0, 0, time.UTC),
+	} {
+		cutoff, err := ParseAgeCutoff(input, now)
+		require.NoError(t, err, input)
+		assert.True(t, expected.Equal(cutoff), "%s: expected %s, got %s", input, expected, cutoff)
+	}
+
+	cutoff, err := ParseAgeCutoff("2024-01-31", now)
+	require.NoError(t, err)
+	assert.True(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.Local).Equal(cutoff))
+
+	for _, input := range []string{"", "14", "fortnight", "-3d", "-1h", "2024-13-01", "90s", "1h30m"} {
+		_, err = ParseAgeCutoff(input, now)
+		require.Error(t, err, input)
+	}
+
+	// Minutes are refused, as months were most likely meant
+	_, err = ParseAgeCutoff("6m", now)
+	require.EqualError(t, err, `invalid age "6m", months are written mo, such as 6mo`)
+}
+
+func TestParseAgeSource(t *testing.T) {
+	source, err := ParseAgeSource("")
+	require.NoError(t, err)
+	assert.Equal(t, AgeFromCommit, source)
+
+	source, err = ParseAgeSource("Merge")
+	require.NoError(t, err)
+	assert.Equal(t, AgeFromMerge, source)
+
+	_, err = ParseAgeSource("author")
+	require.EqualError(t, err, `unknown age source "author", use commit or merge`)
+}
+
+func TestGetMergedBranches_Age(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"}) // 01:00
+	r.checkout("feature-old", base)
+	old := r.commit("old work", map[string]string{"old.txt": "old\n"}) // 02:00
+	r.checkout("master", plumbing.ZeroHash)
+	r.commit("master work", map[string]string{"master.txt": "master\n"})     // 03:00
+	r.merge("Merge feature-old", old)                                        // 04:00
+	recent := r.commit("recent work", map[string]string{"new.txt": "new\n"}) // 05:00
+
+	r.setRef("refs/remotes/origin/master", recent)
+	r.setRef("refs/remotes/origin/feature-old", old)
+	r.setRef("refs/remotes/origin/feature-new", recent)
+
+	cutoff := time.Date(2020, 1, 1, 3, 30, 0, 0, time.UTC)
+	names := func(age AgeFilter) []string {
+		opts := MergedBranchesOptions{Remote: "origin", Masters: []string{"master"}, Age: age}
+		merged, err := GetMergedBranches(r.repo, opts)
+		require.NoError(t, err)
+
+		result := []string{}
+		for _, branch := range merged {
+			result = append(result, branch.Name)
+		}
+		return result
+	}
+
+	assert.Equal(t, []string{"origin/feature-new", "origin/feature-old"}, names(AgeFilter{}))
+	assert.Equal(t, []string{"origin/feature-old"}, names(AgeFilter{OlderThan: cutoff}))
+	assert.Equal(t, []string{"origin/feature-new"}, names(AgeFilter{NewerThan: cutoff}))
+
+	// feature-old was committed at 02:00 but only merged at 04:00
+	assert.Empty(t, names(AgeFilter{OlderThan: cutoff, From: AgeFromMerge}))
+	assert.Equal(t, []string{"origin/feature-new", "origin/feature-old"},
+		names(AgeFilter{NewerThan: cutoff, From: AgeFromMerge}))
+	assert.Equal(t, []string{"origin/feature-old"},
+		names(AgeFilter{OlderThan: cutoff.Add(time.Hour), From: AgeFromMerge}))
+}
+
+func TestNewMasterHistory(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"}) // 01:00
+	r.checkout("feature", base)
+	work := r.commit("feature work", map[string]string{"feature.txt": "a\n"}) // 02:00
+	r.checkout("master", plumbing.ZeroHash)
+	first := r.merge("Merge feature", work) // 03:00
+	r.checkout("feature-more", work)
+	more := r.commit("more feature work", map[string]string{"feature.txt": "b\n"}) // 04:00
+	r.checkout("master", plumbing.ZeroHash)
+	second := r.merge("Merge feature again", more) // 05:00
+
+	index, closer := openCommitNodeIndex(r.repo)
+	defer closer.Close()
+	history, err := newMasterHistory(context.Background(), index, second)
+	require.NoError(t, err)
+
+	date := func(hour int) time.Time { return time.Date(2020, 1, 1, hour, 0, 0, 0, time.UTC) }
+	for hash, expected := range map[plumbing.Hash]time.Time{
+		base:   date(1),
+		work:   date(3),
+		first:  date(3),
+		more:   date(5),
+		second: date(5),
+	} {
+		assert.True(t, expected.Equal(history.merged[hash]), "%s merged at %s", hash, history.merged[hash])
+	}
+}
@@ -0,0 +1,151 @@
+package internal
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	commitgraphfmt "github.com/go-git/go-git/v5/plumbing/format/commitgraph/v2"
+	"github.com/go-git/go-git/v5/plumbing/object/commitgraph"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// openCommitNodeIndex returns an index for walking commits, backed by the
+// repository's commit-graph when one is present. The returned closer releases
+// the commit-graph files and is never nil.
+func openCommitNodeIndex(repo *git.Repository) (commitgraph.CommitNodeIndex, io.Closer) {
+	if storage, ok := repo.Storer.(*filesystem.Storage); ok {
+		graph, err := commitgraphfmt.OpenChainOrFileIndex(storage.Filesystem())
+		if err == nil {
+			LogInfo("Using commit-graph generation numbers for reachability checks")
+			return commitgraph.NewGraphCommitNodeIndex(graph, repo.Storer), graph
+		}
+		LogInfof("No usable commit-graph found (%s), walking commit objects instead", err)
+	}
+
+	return commitgraph.NewObjectCommitNodeIndex(repo.Storer), io.NopCloser(nil)
+}
+
+// reachability answers whether commits are ancestors of a fixed target commit.
+//
+// All questions share a single walk of the target's history, so checking many
+// branch heads costs at most one traversal. Commits are visited in descending
+// generation order: once every pending commit has a lower generation than the
+// commit being looked for, it cannot be reached any more and the walk pauses.
+// Without a commit-graph every generation is unknown and the walk simply
+// continues until the commit is found or history is exhausted.
+type reachability struct {
+	mu      sync.Mutex
+	index   commitgraph.CommitNodeIndex
+	queue   nodeQueue
+	queued  map[plumbing.Hash]bool
+	reached map[plumbing.Hash]bool
+}
+
+func newReachability(index commitgraph.CommitNodeIndex, target plumbing.Hash) (*reachability, error) {
+	node, err := index.Get(target)
+	if err != nil {
+		return nil, fmt.Errorf("loading commit %s failed: %w", target, err)
+	}
+
+	r := &reachability{
+		index:   index,
+		queued:  map[plumbing.Hash]bool{target: true},
+		reached: make(map[plumbing.Hash]bool),
+	}
+	heap.Push(&r.queue, node)
+
+	return r, nil
+}
+
+// contains reports whether hash is the target commit or one of its ancestors.
+func (r *reachability) contains(ctx context.Context, hash plumbing.Hash) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.reached[hash] {
+		return true, nil
+	}
+
+	node, err := r.index.Get(hash)
+	if err != nil {
+		return false, fmt.Errorf("loading commit %s failed: %w", hash, err)
+	}
+	generation := node.Generation()
+
+	for r.queue.Len() > 0 && r.queue[0].Generation() >= generation {
+		select {
+		case <-ctx.Done():
+			return false, ctx.Err()
+		default:
+		}
+
+		current, _ := heap.Pop(&r.queue).(commitgraph.CommitNode)
+		r.reached[current.ID()] = true
+
+		if err := r.enqueueParents(current); err != nil {
+			return false, err
+		}
+
+		if current.ID() == hash {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// enqueueParents queues the parents of node that have not been seen yet. Parents
+// missing from a shallow clone are skipped, as nothing beyond them is known.
+func (r *reachability) enqueueParents(node commitgraph.CommitNode) error {
+	for i, parentHash := range node.ParentHashes() {
+		if r.queued[parentHash] {
+			continue
+		}
+		r.queued[parentHash] = true
+
+		parent, err := node.ParentNode(i)
+		if errors.Is(err, plumbing.ErrObjectNotFound) {
+			LogInfof("Parent %s of %s is missing (shallow clone?), not walking past it", parentHash, node.ID())
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("loading parent %s of %s failed: %w", parentHash, node.ID(), err)
+		}
+
+		heap.Push(&r.queue, parent)
+	}
+
+	return nil
+}
+
+// nodeQueue is a max-heap of commit nodes ordered by generation, then commit time.
+type nodeQueue []commitgraph.CommitNode
+
+func (q nodeQueue) Len() int { return len(q) }
+
+func (q nodeQueue) Less(i, j int) bool {
+	if q[i].Generation() != q[j].Generation() {
+		return q[i].Generation() > q[j].Generation()
+	}
+	return q[i].CommitTime().After(q[j].CommitTime())
+}
+
+func (q nodeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+
+func (q *nodeQueue) Push(x any) {
+	node, _ := x.(commitgraph.CommitNode)
+	*q = append(*q, node)
+}
+
+func (q *nodeQueue) Pop() any {
+	old := *q
+	node := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return node
+}
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object/commitgraph"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReachabilityContains(t *testing.T) {
+	r := newTestRepo(t)
+	root := r.commit("initial", map[string]string{"README.md": "hello\n"})
+
+	var history []plumbing.Hash
+	for i := 0; i < 30; i++ {
+		history = append(history, r.commit(fmt.Sprintf("commit %d", i), map[string]string{"n.txt": fmt.Sprint(i)}))
+	}
+
+	r.checkout("side", history[5])
+	side := r.commit("side work", map[string]string{"side.txt": "side\n"})
+
+	ancestry, err := newReachability(commitgraph.NewObjectCommitNodeIndex(r.repo.Storer), history[len(history)-1])
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	for _, hash := range []plumbing.Hash{history[29], history[10], root, history[3]} {
+		contained, containsErr := ancestry.contains(ctx, hash)
+		require.NoError(t, containsErr)
+		assert.True(t, contained, "%s should be reachable", hash)
+	}
+
+	contained, err := ancestry.contains(ctx, side)
+	require.NoError(t, err)
+	assert.False(t, contained)
+}
+
+func TestGetMergedBranches_ManyBranches(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit("initial", map[string]string{"README.md": "hello\n"})
+
+	expected := make(map[string]DetectionStrategy)
+	for i := 0; i < 15; i++ {
+		head := r.commit(fmt.Sprintf("commit %d", i), map[string]string{"n.txt": fmt.Sprint(i)})
+		r.setRef(fmt.Sprintf("refs/remotes/origin/merged-%02d", i), head)
+		expected[fmt.Sprintf("origin/merged-%02d", i)] = StrategyHash
+	}
+
+	masterHead, err := r.repo.Head()
+	require.NoError(t, err)
+
+	r.checkout("unmerged", masterHead.Hash())
+	r.setRef("refs/remotes/origin/unmerged", r.commit("unmerged", map[string]string{"u.txt": "u\n"}))
+
+	merged, err := GetMergedBranches(r.repo, MergedBranchesOptions{Remote: "origin", Masters: []string{"master"}})
+	require.NoError(t, err)
+	assert.Equal(t, expected, strategiesByName(merged))
+
+	for i := 1; i < len(merged); i++ {
+		assert.Less(t, merged[i-1].Name, merged[i].Name)
+	}
+}
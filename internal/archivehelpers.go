@@ -0,0 +1,116 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// DefaultArchivePrefix is the ref namespace branches are archived under unless
+// another one is given.
+const DefaultArchivePrefix = "refs/archive/"
+
+// ParseArchivePrefix validates a ref namespace to archive branches under, such as
+// "refs/archive/" or "refs/tags/archive/", adding the trailing slash if missing.
+// Branches themselves cannot be the archive, as that would keep them listed.
+func ParseArchivePrefix(prefix string) (string, error) {
+	prefix = strings.TrimSpace(prefix)
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	switch {
+	case !strings.HasPrefix(prefix, "refs/") || prefix == "refs/":
+		return "", fmt.Errorf("archive prefix %q must be a namespace under refs/", prefix)
+	case strings.HasPrefix(prefix, "refs/heads/"), strings.HasPrefix(prefix, "refs/remotes/"):
+		return "", fmt.Errorf("archive prefix %q must not be a branch namespace", prefix)
+	}
+
+	return prefix, nil
+}
+
+// archiveRefName returns the ref the branch named branchShortName is archived as.
+func archiveRefName(prefix, branchShortName string) plumbing.ReferenceName {
+	return plumbing.ReferenceName(prefix + branchShortName)
+}
+
+// archiveBatch pushes the head of each branch at the batch's indexes to its
+// archive ref on remote, and returns the indexes of the branches archived. The
+// errors of the others are stored in errs, so they are not deleted.
+//
+// An archive ref that already exists is not overwritten, unless it already
+// points at the branch head.
+func archiveBatch(
+	repo *git.Repository,
+	remote string,
+	branches []BranchInfo,
+	batch []int,
+	prefix string,
+	errs []error,
+) []int {
+	var refspecs, leases []string
+	var pending []int
+	for _, i := range batch {
+		if branches[i].Hash.IsZero() {
+			errs[i] = fmt.Errorf("cannot archive branch %s without knowing its head", branches[i].Short)
+			continue
+		}
+
+		archiveRef := archiveRefName(prefix, branches[i].Short)
+		refspecs = append(refspecs, fmt.Sprintf("%s:%s", branches[i].Hash, archiveRef))
+		leases = append(leases, archiveRef.String()+":")
+		pending = append(pending, i)
+	}
+
+	if len(pending) == 0 {
+		return nil
+	}
+
+	LogInfof("Archiving %d branches under %s on remote %s", len(pending), prefix, remote)
+	statuses, output, err := pushRefs(repo, remote, refspecs, leases)
+
+	var archived []int
+	for _, i := range pending {
+		archiveRef := archiveRefName(prefix, branches[i].Short)
+		status, ok := statuses[archiveRef]
+
+		var reason error
+		switch {
+		case ok && (status.Flag == pushCreated || status.Flag == pushUpToDate):
+			archived = append(archived, i)
+			continue
+		case ok && strings.Contains(status.Summary, "(stale info)"):
+			reason = fmt.Errorf("%s already exists on remote %s", archiveRef, remote)
+		case ok:
+			reason = errors.New(status.Summary)
+		case err == nil:
+			reason = fmt.Errorf("git push reported no status\nOutput: %s", output)
+		default:
+			reason = fmt.Errorf("%w\nOutput: %s", err, output)
+		}
+		errs[i] = fmt.Errorf("could not archive branch %s to %s: %w", branches[i].Short, archiveRef, reason)
+	}
+
+	return archived
+}
+
+// ArchiveLocalBranch points the archive ref of the local branch under prefix at
+// the branch head, so the branch can be deleted without losing its commits.
+func ArchiveLocalBranch(repo *git.Repository, branch BranchInfo, prefix string) error {
+	archiveRef := archiveRefName(prefix, branch.Short)
+
+	if existing, err := repo.Reference(archiveRef, false); err == nil {
+		if existing.Hash() == branch.Hash {
+			return nil
+		}
+		return fmt.Errorf("could not archive branch %s: %s already exists", branch.Short, archiveRef)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(archiveRef, branch.Hash)); err != nil {
+		return fmt.Errorf("could not archive branch %s to %s: %w", branch.Short, archiveRef, err)
+	}
+	return nil
+}
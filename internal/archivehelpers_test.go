@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseArchivePrefix(t *testing.T) {
+	testCases := []struct {
+		prefix   string
+		expected string
+		err      string
+	}{
+		{prefix: "refs/archive/", expected: "refs/archive/"},
+		{prefix: "refs/tags/archive", expected: "refs/tags/archive/"},
+		{prefix: "archive/", err: `archive prefix "archive/" must be a namespace under refs/`},
+		{prefix: "refs", err: `archive prefix "refs/" must be a namespace under refs/`},
+		{prefix: "refs/heads/archive/", err: `archive prefix "refs/heads/archive/" must not be a branch namespace`},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.prefix, func(t *testing.T) {
+			prefix, err := ParseArchivePrefix(tc.prefix)
+			if tc.err != "" {
+				require.EqualError(t, err, tc.err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.expected, prefix)
+		})
+	}
+}
+
+func TestDeleteBranches_Archive(t *testing.T) {
+	d := newDiskRepo(t)
+	hash := d.commit("initial")
+	d.push(hash, "master", "feature", "taken")
+
+	// An older branch of the same name was archived before
+	older := d.commit("older work")
+	require.NoError(t, d.repo.Push(&git.PushOptions{RemoteName: "origin", RefSpecs: []config.RefSpec{
+		config.RefSpec(older.String() + ":refs/tags/archive/taken"),
+	}}))
+
+	errs := DeleteBranches(context.Background(), d.repo, "origin", []BranchInfo{
+		{Short: "feature", Hash: hash},
+		{Short: "taken", Hash: hash},
+	}, DeleteOptions{ArchivePrefix: "refs/tags/archive/"})
+	require.NoError(t, errs[0])
+	require.EqualError(t, errs[1],
+		"could not archive branch taken to refs/tags/archive/taken: refs/tags/archive/taken already exists on remote origin")
+
+	ref, err := d.origin.Reference("refs/tags/archive/feature", false)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+
+	_, err = d.origin.Reference(plumbing.NewBranchReferenceName("feature"), false)
+	require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+
+	// The branch whose archive failed is kept
+	_, err = d.origin.Reference(plumbing.NewBranchReferenceName("taken"), false)
+	require.NoError(t, err)
+}
+
+func TestArchiveLocalBranch(t *testing.T) {
+	r := newTestRepo(t)
+	hash := r.commit("initial", map[string]string{"README.md": "hello\n"})
+	other := r.commit("more", map[string]string{"README.md": "hello world\n"})
+
+	branch := BranchInfo{Name: "feature", Hash: hash, Short: "feature"}
+	require.NoError(t, ArchiveLocalBranch(r.repo, branch, DefaultArchivePrefix))
+
+	ref, err := r.repo.Reference("refs/archive/feature", false)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+
+	// Archiving again at the same commit is fine, at another one is not
+	require.NoError(t, ArchiveLocalBranch(r.repo, branch, DefaultArchivePrefix))
+	branch.Hash = other
+	require.EqualError(t, ArchiveLocalBranch(r.repo, branch, DefaultArchivePrefix),
+		"could not archive branch feature: refs/archive/feature already exists")
+}
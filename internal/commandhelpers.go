@@ -0,0 +1,331 @@
+package internal
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// Command is a subcommand of a CLI, taking the global flags and its own.
+type Command struct {
+	Name string
+	// Args describes the arguments in the usage, such as "<plan>".
+	Args    string
+	Summary string
+	// Examples are command lines shown in the usage of the command.
+	Examples []string
+	// MinArgs and MaxArgs bound how many arguments the command takes, a negative
+	// MaxArgs leaving it unbounded.
+	MinArgs int
+	MaxArgs int
+	// Flags defines the command's own flags on fs.
+	Flags func(fs *flag.FlagSet)
+	// ArgValues lists the values its arguments may take, for completion.
+	ArgValues func() []string
+	// NoConfig runs the command without reading the configuration, so that a
+	// broken one cannot stop it, as for version or help.
+	NoConfig bool
+	// Run runs the command with its arguments, once its flags are set.
+	Run func(args []string)
+}
+
+// CLI is a command-line tool made of commands. Flags may be given before or
+// after the command, and between its arguments.
+type CLI struct {
+	Name    string
+	Summary string
+	// Global defines the flags every command takes.
+	Global   func(fs *flag.FlagSet)
+	Commands []*Command
+	// NoEnv names the flags that can only be given on the command line, which
+	// the usage lists without an environment variable.
+	NoEnv []string
+	// Aliases maps short names, such as "o", to the flags they stand for. An
+	// alias sets the same value as its flag, is listed along with it and cannot
+	// be set from the environment.
+	Aliases map[string]string
+	// Values lists the values the named flags may take, for completion.
+	Values map[string]func() []string
+
+	sets map[*Command]*flag.FlagSet
+}
+
+// Invocation is a parsed command line.
+type Invocation struct {
+	// Command is nil when none was given.
+	Command *Command
+	// Flags holds the flags of the command, or only the global ones.
+	Flags *flag.FlagSet
+	Args  []string
+}
+
+// Lookup returns the named command, or nil when there is none.
+func (c *CLI) Lookup(name string) *Command {
+	for _, cmd := range c.Commands {
+		if cmd.Name == name {
+			return cmd
+		}
+	}
+	return nil
+}
+
+// FlagSet returns the set of the global flags and those of cmd, which may be
+// nil. The sets of all commands are defined together the first time, as
+// defining a flag resets the variable it is bound to, and are then reused so
+// that the values parsed into them are kept.
+func (c *CLI) FlagSet(cmd *Command) *flag.FlagSet {
+	if c.sets == nil {
+		c.sets = make(map[*Command]*flag.FlagSet, len(c.Commands)+1)
+		c.sets[nil] = c.newFlagSet(nil)
+		for _, command := range c.Commands {
+			c.sets[command] = c.newFlagSet(command)
+		}
+	}
+	return c.sets[cmd]
+}
+
+// newFlagSet defines the global flags and those of cmd on a new set.
+func (c *CLI) newFlagSet(cmd *Command) *flag.FlagSet {
+	name := c.Name
+	if cmd != nil {
+		name += " " + cmd.Name
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	fs.Usage = func() {}
+	if c.Global != nil {
+		c.Global(fs)
+	}
+	if cmd != nil && cmd.Flags != nil {
+		cmd.Flags(fs)
+	}
+	for alias, name := range c.Aliases {
+		if f := fs.Lookup(name); f != nil {
+			fs.Var(f.Value, alias, f.Usage)
+		}
+	}
+	return fs
+}
+
+// Given returns the names of the flags set on fs, aliases standing for their
+// flags.
+func (c *CLI) Given(fs *flag.FlagSet) map[string]bool {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[c.canonical(f.Name)] = true })
+	return given
+}
+
+// canonical returns the name of the flag name stands for, when it is an alias.
+func (c *CLI) canonical(name string) string {
+	if flagName, ok := c.Aliases[name]; ok {
+		return flagName
+	}
+	return name
+}
+
+// Settable returns the names of the flags of every command, sorted, leaving out
+// the NoEnv ones and aliases.
+func (c *CLI) Settable() []string {
+	names := make(map[string]bool)
+	for _, fs := range c.flagSets() {
+		fs.VisitAll(func(f *flag.Flag) { names[f.Name] = true })
+	}
+	for alias := range c.Aliases {
+		delete(names, alias)
+	}
+	for _, name := range c.NoEnv {
+		delete(names, name)
+	}
+
+	settable := make([]string, 0, len(names))
+	for name := range names {
+		settable = append(settable, name)
+	}
+	sort.Strings(settable)
+	return settable
+}
+
+// flagSets returns the flag set of every command, the first holding only the
+// global flags.
+func (c *CLI) flagSets() []*flag.FlagSet {
+	sets := []*flag.FlagSet{c.FlagSet(nil)}
+	for _, cmd := range c.Commands {
+		sets = append(sets, c.FlagSet(cmd))
+	}
+	return sets
+}
+
+// Parse parses the command-line arguments, without the program name. The
+// invocation is returned along with any error, so that the usage of the command
+// can be shown. Asking for help with -h returns flag.ErrHelp.
+func (c *CLI) Parse(args []string) (*Invocation, error) {
+	inv := &Invocation{}
+
+	name, rest := c.splitCommand(args)
+	if name != "" {
+		if inv.Command = c.Lookup(name); inv.Command == nil {
+			inv.Flags = c.FlagSet(nil)
+			return inv, fmt.Errorf("unknown command %q", name)
+		}
+	}
+
+	inv.Flags = c.FlagSet(inv.Command)
+	positional, err := parseInterleaved(inv.Flags, rest)
+	if err != nil {
+		return inv, err
+	}
+	inv.Args = positional
+
+	if cmd := inv.Command; cmd != nil {
+		if len(positional) < cmd.MinArgs {
+			return inv, fmt.Errorf("%s needs %s", cmd.Name, cmd.Args)
+		}
+		if cmd.MaxArgs >= 0 && len(positional) > cmd.MaxArgs {
+			return inv, fmt.Errorf("unexpected arguments for %s: %s",
+				cmd.Name, strings.Join(positional[cmd.MaxArgs:], " "))
+		}
+	}
+	return inv, nil
+}
+
+// splitCommand finds the command among the arguments, skipping the flags before
+// it and their values, and returns it along with the other arguments.
+func (c *CLI) splitCommand(args []string) (string, []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			// The command follows, and anything after it are arguments
+			if i+1 < len(args) {
+				return args[i+1], without(args, i+1)
+			}
+			return "", args
+		}
+		if !strings.HasPrefix(arg, "-") || arg == "-" {
+			return arg, without(args, i)
+		}
+
+		name := strings.TrimLeft(arg, "-")
+		if strings.Contains(name, "=") {
+			continue
+		}
+
+		// The command is not known yet, so the flag is looked up in all of them
+		if f := c.findFlag(nil, name); f != nil && !isBoolFlag(f) {
+			i++
+		}
+	}
+	return "", args
+}
+
+// without returns a copy of args without the one at index i.
+func without(args []string, i int) []string {
+	return append(append([]string{}, args[:i]...), args[i+1:]...)
+}
+
+// isBoolFlag tells whether the flag may be given without a value.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(interface{ IsBoolFlag() bool })
+	return ok && b.IsBoolFlag()
+}
+
+// parseInterleaved parses the flags of args wherever they are, returning the
+// other arguments in order. Everything after "--" is an argument.
+func parseInterleaved(fs *flag.FlagSet, args []string) ([]string, error) {
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, err
+		}
+
+		rest := fs.Args()
+		if len(rest) == 0 {
+			return positional, nil
+		}
+		if len(rest) < len(args) && args[len(args)-len(rest)-1] == "--" {
+			return append(positional, rest...), nil
+		}
+
+		positional = append(positional, rest[0])
+		args = rest[1:]
+	}
+}
+
+// Usage writes the usage of the tool, listing its commands and global flags.
+func (c *CLI) Usage(w io.Writer) {
+	fmt.Fprintf(w, "usage: %s [<flags>] <command> [<args> ...]\n\n", c.Name)
+	fmt.Fprintf(w, "%s\n", c.Summary)
+
+	fmt.Fprintf(w, "\nCommands:\n")
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for _, cmd := range c.Commands {
+		fmt.Fprintf(table, "  %s\t%s\n", cmd.Name, cmd.Summary)
+	}
+	_ = table.Flush()
+
+	fmt.Fprintf(w, "\nGlobal flags:\n")
+	c.printFlags(w, c.FlagSet(nil))
+
+	fmt.Fprintf(w, "\nRun `%s help <command>` for the flags of a command.\n", c.Name)
+}
+
+// CommandUsage writes the usage of the command: its arguments, flags and
+// examples.
+func (c *CLI) CommandUsage(w io.Writer, cmd *Command) {
+	synopsis := fmt.Sprintf("%s %s [<flags>]", c.Name, cmd.Name)
+	if cmd.Args != "" {
+		synopsis += " " + cmd.Args
+	}
+	fmt.Fprintf(w, "usage: %s\n\n%s\n", synopsis, cmd.Summary)
+
+	fmt.Fprintf(w, "\nFlags, which may also be set with the environment variable shown:\n")
+	c.printFlags(w, c.FlagSet(cmd))
+
+	if len(cmd.Examples) > 0 {
+		fmt.Fprintf(w, "\nExamples:\n")
+		for _, example := range cmd.Examples {
+			fmt.Fprintf(w, "  %s\n", example)
+		}
+	}
+}
+
+// printFlags lists the flags of fs with their aliases and environment variables.
+func (c *CLI) printFlags(w io.Writer, fs *flag.FlagSet) {
+	noEnv := make(map[string]bool, len(c.NoEnv))
+	for _, name := range c.NoEnv {
+		noEnv[name] = true
+	}
+	aliases := make(map[string]string, len(c.Aliases))
+	for alias, name := range c.Aliases {
+		aliases[name] = alias
+	}
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fs.VisitAll(func(f *flag.Flag) {
+		if _, ok := c.Aliases[f.Name]; ok {
+			return
+		}
+
+		name, usage := flag.UnquoteUsage(f)
+		if name != "" {
+			name = "=<" + name + ">"
+		}
+
+		env := ""
+		if !noEnv[f.Name] {
+			env = "$" + EnvName(f.Name)
+		}
+		if f.DefValue != "" && f.DefValue != "false" {
+			usage += fmt.Sprintf(" (default %s)", f.DefValue)
+		}
+		alias := ""
+		if a, ok := aliases[f.Name]; ok {
+			alias = "-" + a + ", "
+		}
+		fmt.Fprintf(table, "  %s--%s%s\t%s\t%s\n", alias, f.Name, name, env, usage)
+	})
+	_ = table.Flush()
+}
@@ -0,0 +1,133 @@
+package internal
+
+import (
+	"bytes"
+	"flag"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testCLI holds the flag values of a CLI with a cleanup and a restore command.
+type testCLI struct {
+	debug  bool
+	origin string
+	skip   string
+	force  bool
+	jobs   int
+	run    string
+	out    string
+}
+
+func (v *testCLI) cli() *CLI {
+	return &CLI{
+		Name:    "gitsweeper",
+		Summary: "A command-line tool for cleaning up merged branches.",
+		Global: func(fs *flag.FlagSet) {
+			fs.BoolVar(&v.debug, "debug", false, "Enable debug mode")
+		},
+		Commands: []*Command{
+			{
+				Name:     "cleanup",
+				Summary:  "Delete merged branches",
+				Examples: []string{"gitsweeper cleanup --force"},
+				Flags: func(fs *flag.FlagSet) {
+					fs.StringVar(&v.origin, "origin", "origin", "The remote")
+					fs.StringVar(&v.skip, "skip", "", "Branches to skip")
+					fs.BoolVar(&v.force, "force", false, "Do not ask")
+					fs.IntVar(&v.jobs, "jobs", 1, "Pushes at once")
+				},
+			},
+			{
+				Name:    "restore",
+				Args:    "<branch> ...",
+				Summary: "Restore deleted branches",
+				MinArgs: 1,
+				MaxArgs: -1,
+				Flags: func(fs *flag.FlagSet) {
+					fs.BoolVar(&v.force, "force", false, "Do not ask")
+					fs.StringVar(&v.run, "run", "", "The run")
+					fs.StringVar(&v.out, "out-file", "", "The file to write")
+				},
+			},
+		},
+		NoEnv:   []string{"debug"},
+		Aliases: map[string]string{"o": "out-file"},
+	}
+}
+
+func TestCLI_Parse(t *testing.T) {
+	var v testCLI
+	inv, err := v.cli().Parse([]string{"--origin", "upstream", "--debug", "cleanup", "--jobs=4", "--force"})
+	require.NoError(t, err)
+	assert.Equal(t, "cleanup", inv.Command.Name)
+	assert.Empty(t, inv.Args)
+	assert.Equal(t, testCLI{debug: true, origin: "upstream", force: true, jobs: 4}, v)
+
+	// An alias sets its flag, and counts as giving it
+	v = testCLI{}
+	inv, err = v.cli().Parse([]string{"restore", "r1", "-o", "x.json"})
+	require.NoError(t, err)
+	assert.Equal(t, "x.json", v.out)
+	assert.Equal(t, map[string]bool{"out-file": true}, (&testCLI{}).cli().Given(inv.Flags))
+
+	// Flags after the command override the ones before it
+	v = testCLI{}
+	_, err = v.cli().Parse([]string{"--force", "cleanup", "--force=false"})
+	require.NoError(t, err)
+	assert.False(t, v.force)
+
+	// Arguments may be interleaved with flags, until "--"
+	v = testCLI{}
+	inv, err = v.cli().Parse([]string{"restore", "r1", "--force", "r2", "--run", "20240102-100000", "--", "--r3"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"r1", "r2", "--r3"}, inv.Args)
+	assert.True(t, v.force)
+	assert.Equal(t, "20240102-100000", v.run)
+
+	v = testCLI{}
+	inv, err = v.cli().Parse([]string{"--debug"})
+	require.NoError(t, err)
+	assert.Nil(t, inv.Command)
+	assert.True(t, v.debug)
+
+	for args, expected := range map[string][]string{
+		"unknown command \"nope\"":                   {"--debug", "nope"},
+		"flag provided but not defined: -jobs":       {"restore", "r1", "--jobs", "2"},
+		"restore needs <branch> ...":                 {"restore", "--force"},
+		"unexpected arguments for cleanup: now x":    {"cleanup", "now", "--force", "x"},
+		"invalid boolean value \"maybe\" for -force": {"cleanup", "--force=maybe"},
+	} {
+		_, err = (&testCLI{}).cli().Parse(expected)
+		require.Error(t, err, args)
+		assert.Contains(t, err.Error(), args)
+	}
+
+	_, err = (&testCLI{}).cli().Parse([]string{"cleanup", "-h"})
+	require.ErrorIs(t, err, flag.ErrHelp)
+}
+
+func TestCLI_Usage(t *testing.T) {
+	cli := (&testCLI{}).cli()
+	assert.Equal(t, []string{"force", "jobs", "origin", "out-file", "run", "skip"}, cli.Settable())
+
+	var out bytes.Buffer
+	cli.Usage(&out)
+	assert.Contains(t, out.String(), "usage: gitsweeper [<flags>] <command> [<args> ...]\n\n"+
+		"A command-line tool for cleaning up merged branches.\n")
+	assert.Contains(t, out.String(), "  restore  Restore deleted branches\n")
+	assert.NotContains(t, out.String(), "--jobs")
+
+	out.Reset()
+	cli.CommandUsage(&out, cli.Lookup("cleanup"))
+	assert.Contains(t, out.String(), "usage: gitsweeper cleanup [<flags>]\n")
+	assert.Contains(t, out.String(), "  --jobs=<int>       $GITSWEEPER_JOBS    Pushes at once (default 1)\n")
+	assert.Contains(t, out.String(), "  --debug                                Enable debug mode\n")
+	assert.Contains(t, out.String(), "Examples:\n  gitsweeper cleanup --force\n")
+
+	out.Reset()
+	cli.CommandUsage(&out, cli.Lookup("restore"))
+	assert.Contains(t, out.String(), "  -o, --out-file=<string>  $GITSWEEPER_OUT_FILE  The file to write\n")
+	assert.NotContains(t, out.String(), "--o=")
+}
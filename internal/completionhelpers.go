@@ -0,0 +1,219 @@
+package internal
+
+import (
+	"flag"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/go-git/go-git/v5"
+)
+
+// CompleteCommand is the hidden command the completion scripts call back with
+// the words of the command line up to the cursor, program name included. It
+// prints the candidates for the last word, one per line.
+const CompleteCommand = "__complete"
+
+// Shells lists the shells CompletionScript writes scripts for.
+var Shells = []string{"bash", "zsh", "fish"}
+
+// completionScripts are the templates of the completion scripts, by shell. Each
+// falls back to completing file names when there are no candidates, as for the
+// plan file of apply.
+var completionScripts = map[string]string{
+	"bash": `# bash completion for {{.Name}}, generated by ` + "`{{.Name}} completion bash`" + `.
+# Load it with: source <({{.Name}} completion bash)
+_{{.Name}}() {
+    local line=${COMP_LINE:0:COMP_POINT}
+    local -a words
+    read -ra words <<< "$line"
+    if [[ -z $line || $line == *[[:space:]] ]]; then
+        words+=("")
+    fi
+    local cur=${words[${#words[@]}-1]}
+
+    local IFS=$'\n'
+    COMPREPLY=($(command {{.Name}} {{.Complete}} "${words[@]}" 2>/dev/null))
+
+    # Bash breaks words at "=", so only the part after it is replaced
+    if [[ $cur == *=* && $COMP_WORDBREAKS == *=* ]]; then
+        COMPREPLY=("${COMPREPLY[@]#"${cur%=*}="}")
+    fi
+}
+complete -o default -F _{{.Name}} {{.Name}}
+`,
+	"zsh": `#compdef {{.Name}}
+# zsh completion for {{.Name}}, generated by ` + "`{{.Name}} completion zsh`" + `.
+# Load it with: source <({{.Name}} completion zsh)
+_{{.Name}}() {
+    local -a candidates
+    candidates=("${(@f)$(command {{.Name}} {{.Complete}} "${(@)words[1,CURRENT]}" 2>/dev/null)}")
+    candidates=(${candidates:#})
+    if (( ${#candidates} )); then
+        compadd -- "${candidates[@]}"
+    else
+        _files
+    fi
+}
+
+if [[ $funcstack[1] == _{{.Name}} ]]; then
+    _{{.Name}} "$@"
+else
+    compdef _{{.Name}} {{.Name}}
+fi
+`,
+	"fish": `# fish completion for {{.Name}}, generated by ` + "`{{.Name}} completion fish`" + `.
+# Load it with: {{.Name}} completion fish | source
+function __{{.Name}}_complete
+    set -l words (commandline -opc)
+    set -l cur (commandline -ct)
+    set -l candidates (command {{.Name}} {{.Complete}} $words "$cur" 2>/dev/null)
+    if test (count $candidates) -gt 0
+        printf '%s\n' $candidates
+    else
+        __fish_complete_path "$cur"
+    end
+end
+complete -c {{.Name}} -f -a '(__{{.Name}}_complete)'
+`,
+}
+
+// CompletionScript returns the script completing the tool's commands, flags and
+// their values in shell, by calling back CompleteCommand.
+func (c *CLI) CompletionScript(shell string) (string, error) {
+	text, ok := completionScripts[shell]
+	if !ok {
+		return "", fmt.Errorf("unknown shell %q, use %s", shell, strings.Join(Shells, ", "))
+	}
+
+	tmpl := template.Must(template.New(shell).Parse(text))
+	var script strings.Builder
+	if err := tmpl.Execute(&script, map[string]string{"Name": c.Name, "Complete": CompleteCommand}); err != nil {
+		return "", fmt.Errorf("writing the %s completion failed: %w", shell, err)
+	}
+	return script.String(), nil
+}
+
+// Complete returns the candidates for the last of words, the command line up to
+// the cursor without the program name. The flags before the word are parsed as
+// usual, so that the Values completers may read them, such as the remote whose
+// branches to complete.
+func (c *CLI) Complete(words []string) []string {
+	if len(words) == 0 {
+		words = []string{""}
+	}
+	cur, prev := words[len(words)-1], words[:len(words)-1]
+
+	name, rest := c.splitCommand(prev)
+	cmd := c.Lookup(name)
+	if name != "" && cmd == nil {
+		return nil
+	}
+
+	// Flags up to a mistake are still set, which is enough to complete
+	fs := c.FlagSet(cmd)
+	_, _ = parseInterleaved(fs, rest)
+
+	// The word is the value of the flag before it
+	if n := len(prev); n > 0 && strings.HasPrefix(prev[n-1], "-") && !strings.Contains(prev[n-1], "=") {
+		if f := c.findFlag(cmd, strings.TrimLeft(prev[n-1], "-")); f != nil && !isBoolFlag(f) {
+			return c.completeValue(f.Name, cur, "")
+		}
+	}
+
+	if strings.HasPrefix(cur, "-") {
+		if flagName, value, ok := strings.Cut(strings.TrimLeft(cur, "-"), "="); ok {
+			return c.completeValue(c.canonical(flagName), value, cur[:len(cur)-len(value)])
+		}
+
+		var names []string
+		fs.VisitAll(func(f *flag.Flag) {
+			if _, ok := c.Aliases[f.Name]; !ok {
+				names = append(names, "--"+f.Name)
+			}
+		})
+		return withPrefix(names, cur)
+	}
+
+	if cmd == nil {
+		names := make([]string, 0, len(c.Commands))
+		for _, command := range c.Commands {
+			names = append(names, command.Name)
+		}
+		return withPrefix(names, cur)
+	}
+	if cmd.ArgValues != nil {
+		return withPrefix(cmd.ArgValues(), cur)
+	}
+	return nil
+}
+
+// findFlag looks up the named flag of cmd, or of any command when cmd is nil.
+func (c *CLI) findFlag(cmd *Command, name string) *flag.Flag {
+	if cmd != nil {
+		return c.FlagSet(cmd).Lookup(name)
+	}
+
+	for _, fs := range c.flagSets() {
+		if f := fs.Lookup(name); f != nil {
+			return f
+		}
+	}
+	return nil
+}
+
+// completeValue returns the candidates for the value of the named flag, each
+// preceded by prefix. In a comma-separated list only the last item is completed.
+func (c *CLI) completeValue(name, value, prefix string) []string {
+	values := c.Values[name]
+	if values == nil {
+		return nil
+	}
+
+	head := value[:strings.LastIndex(value, ",")+1]
+	candidates := withPrefix(values(), value[len(head):])
+	for i, candidate := range candidates {
+		candidates[i] = prefix + head + candidate
+	}
+	return candidates
+}
+
+// withPrefix returns the candidates starting with prefix.
+func withPrefix(candidates []string, prefix string) []string {
+	var matching []string
+	for _, candidate := range candidates {
+		if strings.HasPrefix(candidate, prefix) {
+			matching = append(matching, candidate)
+		}
+	}
+	return matching
+}
+
+// RemoteNames returns the names of the repository's remotes, sorted.
+func RemoteNames(repo *git.Repository) ([]string, error) {
+	remotes, err := repo.Remotes()
+	if err != nil {
+		return nil, fmt.Errorf("list remotes failed: %w", err)
+	}
+
+	names := RemoteBranchesToStrings(remotes)
+	sort.Strings(names)
+	return names, nil
+}
+
+// BranchNames returns the short names of the remote's branches, along with the
+// local ones when includeLocal is set, sorted and without duplicates.
+func BranchNames(repo *git.Repository, remoteOrigin string, includeLocal bool) ([]string, error) {
+	names, err := branchNames(repo, remoteOrigin, includeLocal)
+	if err != nil {
+		return nil, err
+	}
+
+	unique := make([]string, 0, len(names))
+	for name := range StringSliceToSet(names) {
+		unique = append(unique, name)
+	}
+	sort.Strings(unique)
+	return unique, nil
+}
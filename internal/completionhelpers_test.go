@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCLI_Complete(t *testing.T) {
+	var v testCLI
+	cli := v.cli()
+
+	// The branches completed are the ones of the remote given before the word
+	cli.Values = map[string]func() []string{
+		"origin": func() []string { return []string{"origin", "upstream"} },
+		"skip": func() []string {
+			if v.origin == "upstream" {
+				return []string{"fork-a"}
+			}
+			return []string{"feature-a", "feature-b"}
+		},
+		"run": func() []string { return []string{"20240102-100000", "20240103-100000"} },
+	}
+	cli.Commands[1].ArgValues = func() []string { return []string{"origin/feature-a", "origin/feature-b"} }
+
+	for line, expected := range map[string][]string{
+		"":                                   {"cleanup", "restore"},
+		"re":                                 {"restore"},
+		"cleanup --":                         {"--debug", "--force", "--jobs", "--origin", "--skip"},
+		"cleanup --o":                        {"--origin"},
+		"cleanup --origin ":                  {"origin", "upstream"},
+		"--origin u":                         {"upstream"},
+		"cleanup --origin=u":                 {"--origin=upstream"},
+		"cleanup --skip ":                    {"feature-a", "feature-b"},
+		"cleanup --skip feature-a,feature-":  {"feature-a,feature-a", "feature-a,feature-b"},
+		"--origin upstream cleanup --skip=":  {"--skip=fork-a"},
+		"cleanup --skip f --origin=upstream": {"--origin=upstream"},
+		"cleanup --jobs ":                    nil,
+		"cleanup --force ":                   nil,
+		"cleanup x --run ":                   nil,
+		"restore --force origin/feature-a ":  {"origin/feature-a", "origin/feature-b"},
+		"restore --run 2024010":              {"20240102-100000", "20240103-100000"},
+		"restore --run=20240103 origin/fe":   {"origin/feature-a", "origin/feature-b"},
+		"nope ":                              nil,
+		"--debug restore --run 20240102 --f": {"--force"},
+		"restore --o":                        {"--out-file"},
+	} {
+		v = testCLI{}
+		assert.Equal(t, expected, cli.Complete(splitWords(line)), line)
+	}
+}
+
+// splitWords splits a command line at spaces, keeping an empty last word when
+// it ends with one, as the completion scripts do.
+func splitWords(line string) []string {
+	var words []string
+	word := ""
+	for _, r := range line {
+		if r == ' ' {
+			words = append(words, word)
+			word = ""
+			continue
+		}
+		word += string(r)
+	}
+	return append(words, word)
+}
+
+func TestCLI_CompletionScript(t *testing.T) {
+	cli := (&testCLI{}).cli()
+
+	for _, shell := range Shells {
+		script, err := cli.CompletionScript(shell)
+		require.NoError(t, err, shell)
+		assert.Contains(t, script, "gitsweeper "+CompleteCommand+" ", shell)
+		assert.Contains(t, script, "gitsweeper completion "+shell, shell)
+	}
+
+	_, err := cli.CompletionScript("powershell")
+	require.EqualError(t, err, `unknown shell "powershell", use bash, zsh, fish`)
+}
+
+func TestRemoteAndBranchNames(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"})
+	_, err := r.repo.CreateRemote(&config.RemoteConfig{Name: "fork", URLs: []string{"https://example.com/fork.git"}})
+	require.NoError(t, err)
+
+	r.setRef("refs/remotes/origin/master", base)
+	r.setRef("refs/remotes/origin/feature", base)
+	r.setRef("refs/remotes/fork/other", base)
+	r.setRef("refs/heads/feature", base)
+	r.setRef("refs/heads/local-only", base)
+
+	remotes, err := RemoteNames(r.repo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"fork", "origin"}, remotes)
+
+	branches, err := BranchNames(r.repo, "origin", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"feature", "master"}, branches)
+
+	branches, err = BranchNames(r.repo, "origin", true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"feature", "local-only", "master"}, branches)
+}
@@ -0,0 +1,319 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"gopkg.in/yaml.v3"
+)
+
+// RepoConfigFile is the configuration file committed at the root of a
+// repository's worktree.
+const RepoConfigFile = ".gitsweeper.yml"
+
+// userConfigFile is the user's configuration file, relative to the XDG config
+// directory.
+const userConfigFile = "gitsweeper/config.yml"
+
+// EnvPrefix starts the names of the environment variables setting options, as
+// in GITSWEEPER_ORIGIN.
+const EnvPrefix = "GITSWEEPER_"
+
+// repoFileSettings are the only options RepoConfigFile may set. They choose
+// which branches are looked at, so that a repository someone clones cannot turn
+// off the question before deleting or pick the files gitsweeper writes.
+var repoFileSettings = map[string]bool{
+	"origin":            true,
+	"master":            true,
+	"master-candidates": true,
+	"skip":              true,
+	"only":              true,
+	"detect":            true,
+	"prefer-local":      true,
+	"all-masters":       true,
+	"older-than":        true,
+	"newer-than":        true,
+	"age-from":          true,
+}
+
+// gitConfigSection is the git config section holding settings, as in
+// `git config gitsweeper.origin upstream`.
+const gitConfigSection = "gitsweeper"
+
+// Setting is the value of an option and where it came from, such as the path
+// of a configuration file.
+type Setting struct {
+	Name   string
+	Value  string
+	Source string
+}
+
+// Settings holds the values of options read from configuration, keyed by the
+// names of their flags. A value set later replaces an earlier one, so sources
+// are loaded from the lowest precedence to the highest.
+type Settings struct {
+	// names maps the normalized form of each known option to its flag name.
+	names  map[string]string
+	values map[string]Setting
+}
+
+// NewSettings returns empty settings accepting the named options.
+func NewSettings(names []string) *Settings {
+	s := &Settings{names: make(map[string]string, len(names)), values: make(map[string]Setting)}
+	for _, name := range names {
+		s.names[normalizeSettingName(name)] = name
+	}
+	return s
+}
+
+// normalizeSettingName lets git config keys, which cannot tell case apart, and
+// camelCase YAML keys name options like "master-candidates".
+func normalizeSettingName(name string) string {
+	return strings.ToLower(strings.NewReplacer("-", "", "_", "").Replace(name))
+}
+
+// Set records the value of the named option from source.
+func (s *Settings) Set(name, value, source string) error {
+	flagName, ok := s.flagName(name)
+	if !ok {
+		return fmt.Errorf("unknown setting %q in %s", name, source)
+	}
+
+	s.values[flagName] = Setting{Name: flagName, Value: value, Source: source}
+	return nil
+}
+
+// flagName returns the name of the flag a setting is for, and whether it names
+// a known option.
+func (s *Settings) flagName(name string) (string, bool) {
+	flagName, ok := s.names[normalizeSettingName(name)]
+	return flagName, ok
+}
+
+// setKnown records the value of the named option from source, warning about and
+// ignoring options it does not know, which may be meant for another version.
+func (s *Settings) setKnown(name, value, source string) {
+	if err := s.Set(name, value, source); err != nil {
+		LogWarnf("Ignoring %s in %s, which sets no option", name, source)
+	}
+}
+
+// Get returns the value of the named option, and whether any source set it.
+func (s *Settings) Get(name string) (Setting, bool) {
+	setting, ok := s.values[name]
+	return setting, ok
+}
+
+// All returns every option that was set, sorted by name.
+func (s *Settings) All() []Setting {
+	all := make([]Setting, 0, len(s.values))
+	for _, setting := range s.values {
+		all = append(all, setting)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Name < all[j].Name })
+	return all
+}
+
+// LoadFile reads settings from a YAML file mapping option names to values. Lists
+// are joined with commas, as the flags take them. A missing file sets nothing,
+// and unknown options are ignored with a warning.
+func (s *Settings) LoadFile(path string) error {
+	return s.loadFile(path, nil)
+}
+
+// loadFile reads settings from a YAML file like LoadFile, ignoring with a warning
+// the options allowed does not hold, unless it is nil.
+func (s *Settings) loadFile(path string, allowed map[string]bool) error {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("reading %s failed: %w", path, err)
+	}
+
+	var values map[string]interface{}
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("parsing %s failed: %w", path, err)
+	}
+
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value, err := settingValue(values[name])
+		if err != nil {
+			return fmt.Errorf("setting %q in %s: %w", name, path, err)
+		}
+
+		if flagName, ok := s.flagName(name); ok && allowed != nil && !allowed[flagName] {
+			LogWarnf("Ignoring %s in %s, which only flags, the environment or your own configuration may set",
+				name, path)
+			continue
+		}
+		s.setKnown(name, value, path)
+	}
+	return nil
+}
+
+// settingValue turns a YAML value into the string a flag would be given.
+func settingValue(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case []interface{}:
+		items := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := settingValue(item)
+			if err != nil {
+				return "", err
+			}
+			items = append(items, s)
+		}
+		return strings.Join(items, ","), nil
+	}
+	return "", fmt.Errorf("unsupported value %v, use a string, number, boolean or list", value)
+}
+
+// LoadGitConfig reads settings from the gitsweeper section of a git config.
+// Keys given more than once are joined with commas, and unknown keys are ignored
+// with a warning.
+func (s *Settings) LoadGitConfig(cfg *config.Config, source string) error {
+	if cfg == nil || cfg.Raw == nil {
+		return nil
+	}
+
+	for _, section := range cfg.Raw.Sections {
+		if !section.IsName(gitConfigSection) {
+			continue
+		}
+
+		var keys []string
+		values := make(map[string][]string)
+		for _, option := range section.Options {
+			key := strings.ToLower(option.Key)
+			if _, ok := values[key]; !ok {
+				keys = append(keys, key)
+			}
+			values[key] = append(values[key], option.Value)
+		}
+
+		for _, key := range keys {
+			s.setKnown(key, strings.Join(values[key], ","), source)
+		}
+	}
+	return nil
+}
+
+// EnvName returns the environment variable setting the named option, such as
+// GITSWEEPER_MASTER_CANDIDATES for "master-candidates".
+func EnvName(name string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+// LoadEnv reads settings from the EnvPrefix variables of environ, given as
+// "KEY=value" like os.Environ returns them. Variables naming no option are
+// ignored with a warning, as they may be meant for another version.
+func (s *Settings) LoadEnv(environ []string) {
+	sort.Strings(environ)
+	for _, variable := range environ {
+		key, value, _ := strings.Cut(variable, "=")
+		if !strings.HasPrefix(key, EnvPrefix) {
+			continue
+		}
+
+		source := "env " + key
+		if err := s.Set(strings.TrimPrefix(key, EnvPrefix), value, source); err != nil {
+			LogWarnf("Ignoring %s, which sets no option", key)
+		}
+	}
+}
+
+// UserConfigPath returns the path of the user's configuration file, under
+// XDG_CONFIG_HOME or else ~/.config.
+func UserConfigPath() (string, error) {
+	if dir := os.Getenv("XDG_CONFIG_HOME"); dir != "" {
+		return filepath.Join(dir, filepath.FromSlash(userConfigFile)), nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("finding the user configuration failed: %w", err)
+	}
+	return filepath.Join(home, ".config", filepath.FromSlash(userConfigFile)), nil
+}
+
+// LoadSettings reads the settings of the named options from configuration, from
+// the lowest precedence to the highest:
+//
+//  1. the user's configuration file, see UserConfigPath
+//  2. gitsweeper.* keys of the user's git config
+//  3. RepoConfigFile at the root of the repository's worktree, which may only
+//     set the options choosing branches, see repoFileSettings
+//  4. gitsweeper.* keys of the repository's git config
+//  5. EnvPrefix environment variables, see EnvName
+//
+// Git config overrides the file at the same level as it is not shared. Without
+// a repository, which may be nil, the repository's settings are left out.
+func LoadSettings(repo *git.Repository, names []string) (*Settings, error) {
+	settings := NewSettings(names)
+
+	userPath, err := UserConfigPath()
+	if err == nil {
+		err = settings.LoadFile(userPath)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	globalConfig, err := config.LoadConfig(config.GlobalScope)
+	if err != nil {
+		return nil, fmt.Errorf("reading the user's git config failed: %w", err)
+	}
+	if err = settings.LoadGitConfig(globalConfig, "git config (global)"); err != nil {
+		return nil, err
+	}
+
+	if repo != nil {
+		if err = settings.loadRepository(repo); err != nil {
+			return nil, err
+		}
+	}
+
+	settings.LoadEnv(os.Environ())
+	return settings, nil
+}
+
+// loadRepository reads the settings of the repository, from RepoConfigFile and
+// then its git config. Unlike the file, the git config is not shared by cloning,
+// so it may set any option.
+func (s *Settings) loadRepository(repo *git.Repository) error {
+	if worktree, wtErr := repo.Worktree(); wtErr == nil {
+		path := filepath.Join(worktree.Filesystem.Root(), RepoConfigFile)
+		if err := s.loadFile(path, repoFileSettings); err != nil {
+			return err
+		}
+	}
+
+	localConfig, err := repo.ConfigScoped(config.LocalScope)
+	if err != nil {
+		return fmt.Errorf("reading the repository's git config failed: %w", err)
+	}
+	return s.LoadGitConfig(localConfig, "git config (local)")
+}
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var testSettingNames = []string{"origin", "master", "skip", "force", "jobs", "master-candidates"}
+
+func TestSettings_LoadFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	require.NoError(t, os.WriteFile(path, []byte(
+		"origin: upstream\nmaster: [main, 'release/*']\nforce: true\njobs: 4\nmasterCandidates: trunk\nskip:\n"), 0o600))
+
+	settings := NewSettings(testSettingNames)
+	require.NoError(t, settings.LoadFile(path))
+	assert.Equal(t, []Setting{
+		{Name: "force", Value: "true", Source: path},
+		{Name: "jobs", Value: "4", Source: path},
+		{Name: "master", Value: "main,release/*", Source: path},
+		{Name: "master-candidates", Value: "trunk", Source: path},
+		{Name: "origin", Value: "upstream", Source: path},
+		{Name: "skip", Value: "", Source: path},
+	}, settings.All())
+
+	// A missing file sets nothing
+	settings = NewSettings(testSettingNames)
+	require.NoError(t, settings.LoadFile(filepath.Join(t.TempDir(), "missing.yml")))
+	assert.Empty(t, settings.All())
+
+	// Unknown options, which may be meant for another version, are ignored
+	require.NoError(t, os.WriteFile(path, []byte("orign: upstream\norigin: fork\n"), 0o600))
+	require.NoError(t, settings.LoadFile(path))
+	assert.Equal(t, []Setting{{Name: "origin", Value: "fork", Source: path}}, settings.All())
+
+	require.NoError(t, os.WriteFile(path, []byte("origin: {name: upstream}\n"), 0o600))
+	require.Error(t, settings.LoadFile(path))
+
+	require.NoError(t, os.WriteFile(path, []byte("origin: [\n"), 0o600))
+	require.Error(t, settings.LoadFile(path))
+}
+
+func TestLoadSettings(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+
+	userPath, err := UserConfigPath()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(home, "xdg", "gitsweeper", "config.yml"), userPath)
+
+	require.NoError(t, os.MkdirAll(filepath.Dir(userPath), 0o755))
+	require.NoError(t, os.WriteFile(userPath, []byte("origin: mine\nskip: user-skip\njobs: 2\n"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".gitconfig"),
+		[]byte("[gitsweeper]\n\tjobs = 3\n\tforce = true\n"), 0o600))
+
+	// Without a repository only the user's settings apply
+	settings, err := LoadSettings(nil, testSettingNames)
+	require.NoError(t, err)
+	setting, ok := settings.Get("jobs")
+	require.True(t, ok)
+	assert.Equal(t, Setting{Name: "jobs", Value: "3", Source: "git config (global)"}, setting)
+
+	dir := filepath.Join(t.TempDir(), "repo")
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	// The repository's file cannot set options other than the ones choosing
+	// branches, so jobs and force are left as the user set them
+	require.NoError(t, os.WriteFile(filepath.Join(dir, RepoConfigFile),
+		[]byte("origin: upstream\nskip: [a, b]\nforce: false\njobs: 8\n"), 0o600))
+
+	cfg, err := repo.Config()
+	require.NoError(t, err)
+	section := cfg.Raw.Section("gitsweeper")
+	section.AddOption("skip", "c")
+	section.AddOption("skip", "d")
+	section.AddOption("masterCandidates", "trunk")
+	require.NoError(t, repo.SetConfig(cfg))
+
+	settings, err = LoadSettings(repo, testSettingNames)
+	require.NoError(t, err)
+
+	expected := map[string]Setting{
+		"origin":            {Name: "origin", Value: "upstream", Source: filepath.Join(dir, RepoConfigFile)},
+		"skip":              {Name: "skip", Value: "c,d", Source: "git config (local)"},
+		"master-candidates": {Name: "master-candidates", Value: "trunk", Source: "git config (local)"},
+		"jobs":              {Name: "jobs", Value: "3", Source: "git config (global)"},
+		"force":             {Name: "force", Value: "true", Source: "git config (global)"},
+	}
+	for name, want := range expected {
+		got, found := settings.Get(name)
+		require.True(t, found, name)
+		assert.Equal(t, want, got, name)
+	}
+	_, ok = settings.Get("master")
+	assert.False(t, ok)
+
+	// Unknown keys in git config are ignored too
+	cfg.Raw.Section("gitsweeper").AddOption("nope", "1")
+	require.NoError(t, repo.SetConfig(cfg))
+	_, err = LoadSettings(repo, testSettingNames)
+	require.NoError(t, err)
+
+	// Other sections are ignored
+	settings = NewSettings(testSettingNames)
+	require.NoError(t, settings.LoadGitConfig(config.NewConfig(), "empty"))
+	assert.Empty(t, settings.All())
+}
+
+func TestSettings_LoadEnv(t *testing.T) {
+	assert.Equal(t, "GITSWEEPER_MASTER_CANDIDATES", EnvName("master-candidates"))
+	assert.Equal(t, "GITSWEEPER_ORIGIN", EnvName("origin"))
+
+	settings := NewSettings(testSettingNames)
+	require.NoError(t, settings.Set("origin", "upstream", "a file"))
+	settings.LoadEnv([]string{
+		"PATH=/usr/bin",
+		"GITSWEEPER_ORIGIN=fork",
+		"GITSWEEPER_MASTER_CANDIDATES=main,trunk",
+		"GITSWEEPER_SKIP=",
+		"GITSWEEPER_UNKNOWN=1",
+	})
+
+	assert.Equal(t, []Setting{
+		{Name: "master-candidates", Value: "main,trunk", Source: "env GITSWEEPER_MASTER_CANDIDATES"},
+		{Name: "origin", Value: "fork", Source: "env GITSWEEPER_ORIGIN"},
+		{Name: "skip", Value: "", Source: "env GITSWEEPER_SKIP"},
+	}, settings.All())
+}
+
+func TestLoadSettings_RepoFileCannotForce(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, "xdg"))
+
+	dir := filepath.Join(t.TempDir(), "repo")
+	repo, err := git.PlainInit(dir, false)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(dir, RepoConfigFile), []byte("force: true\norigin: upstream\n"), 0o600))
+
+	settings, err := LoadSettings(repo, testSettingNames)
+	require.NoError(t, err)
+	_, ok := settings.Get("force")
+	assert.False(t, ok)
+	_, ok = settings.Get("origin")
+	assert.True(t, ok)
+
+	// The repository's git config is not shared by cloning, so it may
+	cfg, err := repo.Config()
+	require.NoError(t, err)
+	cfg.Raw.Section("gitsweeper").AddOption("force", "true")
+	require.NoError(t, repo.SetConfig(cfg))
+
+	settings, err = LoadSettings(repo, testSettingNames)
+	require.NoError(t, err)
+	setting, ok := settings.Get("force")
+	require.True(t, ok)
+	assert.Equal(t, Setting{Name: "force", Value: "true", Source: "git config (local)"}, setting)
+}
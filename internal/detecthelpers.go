@@ -0,0 +1,591 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	fdiff "github.com/go-git/go-git/v5/plumbing/format/diff"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/object/commitgraph"
+)
+
+// DetectionStrategy names a way of deciding that a branch has been merged.
+type DetectionStrategy string
+
+const (
+	// StrategyHash reports a branch as merged when its head commit is part of master.
+	StrategyHash DetectionStrategy = "hash"
+	// StrategySquash reports a branch as merged when its cumulative diff since the
+	// merge-base was applied to master as a single commit ("Squash and merge").
+	StrategySquash DetectionStrategy = "squash"
+	// StrategyRebase reports a branch as merged when every commit unique to it has a
+	// patch-equivalent commit on master ("Rebase and merge"), like `git cherry`.
+	StrategyRebase DetectionStrategy = "rebase"
+)
+
+// MergedBranch is a branch found to be merged, along with the strategy that matched it.
+type MergedBranch struct {
+	BranchInfo
+	Strategy DetectionStrategy
+	// Masters lists the master branches the branch has been merged into.
+	Masters []string
+	// CheckedOutIn is the path of the worktree a local branch is checked out in.
+	// Such branches are reported but must not be deleted.
+	CheckedOutIn string
+}
+
+// ParseDetectionStrategies parses a comma-separated list of detection strategies.
+// The hash strategy is always enabled, as every other strategy builds on it.
+func ParseDetectionStrategies(s string) ([]DetectionStrategy, error) {
+	strategies := []DetectionStrategy{StrategyHash}
+	seen := map[DetectionStrategy]bool{StrategyHash: true}
+
+	for _, name := range strings.Split(s, ",") {
+		strategy := DetectionStrategy(strings.ToLower(strings.TrimSpace(name)))
+		if strategy == "" || seen[strategy] {
+			continue
+		}
+
+		switch strategy {
+		case StrategyHash, StrategySquash, StrategyRebase:
+		default:
+			return nil, fmt.Errorf("unknown detection strategy %q", name)
+		}
+
+		seen[strategy] = true
+		strategies = append(strategies, strategy)
+	}
+
+	return strategies, nil
+}
+
+// usesPatchIDs reports whether any of strategies needs patch-id comparisons.
+func usesPatchIDs(strategies []DetectionStrategy) bool {
+	for _, strategy := range strategies {
+		if strategy == StrategySquash || strategy == StrategyRebase {
+			return true
+		}
+	}
+	return false
+}
+
+// patchID identifies a change independently of where it was applied, in the spirit
+// of `git patch-id`: line numbers, context lines and whitespace are ignored.
+type patchID [sha256.Size]byte
+
+// computePatchID returns the patch-id of the change between the trees of from and to.
+// The boolean result is false when the two trees are identical.
+func computePatchID(ctx context.Context, from, to *object.Commit) (patchID, bool, error) {
+	fromTree, err := from.Tree()
+	if err != nil {
+		return patchID{}, false, fmt.Errorf("reading tree of %s failed: %w", from.Hash, err)
+	}
+
+	toTree, err := to.Tree()
+	if err != nil {
+		return patchID{}, false, fmt.Errorf("reading tree of %s failed: %w", to.Hash, err)
+	}
+
+	changes, err := object.DiffTreeContext(ctx, fromTree, toTree)
+	if err != nil {
+		return patchID{}, false, fmt.Errorf("diffing %s..%s failed: %w", from.Hash, to.Hash, err)
+	}
+
+	if len(changes) == 0 {
+		return patchID{}, false, nil
+	}
+
+	patch, err := changes.PatchContext(ctx)
+	if err != nil {
+		return patchID{}, false, fmt.Errorf("building patch %s..%s failed: %w", from.Hash, to.Hash, err)
+	}
+
+	filePatches := patch.FilePatches()
+	sort.Slice(filePatches, func(i, j int) bool {
+		return filePatchPath(filePatches[i]) < filePatchPath(filePatches[j])
+	})
+
+	hasher := sha256.New()
+	for _, fp := range filePatches {
+		writeFilePatch(hasher, fp)
+	}
+
+	var id patchID
+	copy(id[:], hasher.Sum(nil))
+	return id, true, nil
+}
+
+// filePatchPath returns the path a file patch applies to, preferring the new path.
+func filePatchPath(fp fdiff.FilePatch) string {
+	from, to := fp.Files()
+	if to != nil {
+		return to.Path()
+	}
+	if from != nil {
+		return from.Path()
+	}
+	return ""
+}
+
+// writeFilePatch feeds the parts of a file patch that identify the change into w.
+func writeFilePatch(w io.Writer, fp fdiff.FilePatch) {
+	from, to := fp.Files()
+	if from != nil {
+		fmt.Fprintf(w, "--- %s\n", from.Path())
+	}
+	if to != nil {
+		fmt.Fprintf(w, "+++ %s\n", to.Path())
+	}
+
+	if fp.IsBinary() {
+		if to != nil {
+			fmt.Fprintf(w, "binary %s\n", to.Hash())
+		} else {
+			fmt.Fprintf(w, "binary deleted\n")
+		}
+		return
+	}
+
+	for _, chunk := range fp.Chunks() {
+		var prefix string
+		switch chunk.Type() {
+		case fdiff.Add:
+			prefix = "+"
+		case fdiff.Delete:
+			prefix = "-"
+		case fdiff.Equal:
+			continue
+		}
+
+		for _, line := range strings.Split(strings.TrimSuffix(chunk.Content(), "\n"), "\n") {
+			fmt.Fprintf(w, "%s%s\n", prefix, strings.Join(strings.Fields(line), ""))
+		}
+	}
+}
+
+// patchIndex lazily walks the first-parent history of master, remembering the
+// patch-id of every commit it has seen so each branch check can reuse them.
+type patchIndex struct {
+	mu    sync.Mutex
+	chain []*object.Commit
+	ids   map[plumbing.Hash]patchID
+	next  *object.Commit
+	done  bool
+}
+
+func newPatchIndex(master *object.Commit) *patchIndex {
+	return &patchIndex{
+		ids:  make(map[plumbing.Hash]patchID),
+		next: master,
+	}
+}
+
+// commitAt returns the i-th commit on master's first-parent chain. The boolean
+// result is false once the root commit has been passed.
+func (s *patchIndex) commitAt(i int) (*object.Commit, bool, error) {
+	for len(s.chain) <= i && !s.done {
+		current := s.next
+		s.chain = append(s.chain, current)
+
+		if current.NumParents() == 0 {
+			s.done = true
+			break
+		}
+
+		parent, err := current.Parent(0)
+		if err != nil {
+			return nil, false, fmt.Errorf("walking master history failed: %w", err)
+		}
+		s.next = parent
+	}
+
+	if i >= len(s.chain) {
+		return nil, false, nil
+	}
+	return s.chain[i], true, nil
+}
+
+// find looks for a commit on master, made since base, whose patch-id equals id.
+// The boolean result is false when no such commit exists.
+//
+// Squash and rebase merges both land on master's first-parent chain, so only that
+// chain is searched. The walk stops at the first commit base contains, base itself
+// or one of its ancestors when the branch was forked from somewhere off the
+// chain, asking base's reachability rather than going by commit dates, which
+// may be skewed.
+func (s *patchIndex) find(ctx context.Context, id patchID, base *reachability) (*object.Commit, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := 0; ; i++ {
+		select {
+		case <-ctx.Done():
+			return nil, false, ctx.Err()
+		default:
+		}
+
+		commit, ok, err := s.commitAt(i)
+		if err != nil {
+			return nil, false, err
+		}
+
+		if !ok {
+			return nil, false, nil
+		}
+		if contained, err := base.contains(ctx, commit.Hash); err != nil || contained {
+			return nil, false, err
+		}
+
+		// Merge commits are never squash commits, and root commits have nothing to diff against.
+		if commit.NumParents() != 1 {
+			continue
+		}
+
+		commitID, ok := s.ids[commit.Hash]
+		if !ok {
+			parent, parentErr := commit.Parent(0)
+			if parentErr != nil {
+				return nil, false, fmt.Errorf("loading parent of %s failed: %w", commit.Hash, parentErr)
+			}
+
+			commitID, _, err = computePatchID(ctx, parent, commit)
+			if err != nil {
+				return nil, false, err
+			}
+			s.ids[commit.Hash] = commitID
+		}
+
+		if commitID == id {
+			return commit, true, nil
+		}
+	}
+}
+
+// branchOnlyCommits returns the commits reachable from head but not from master,
+// like `git rev-list master..head`, newest first. The walk from head stops at
+// every commit master contains, asking master's reachability rather than going
+// by commit dates, which tie or are skewed in scripted histories.
+func branchOnlyCommits(ctx context.Context, head *object.Commit, master *reachability) ([]*object.Commit, error) {
+	var commits []*object.Commit
+	queue := []*object.Commit{head}
+	queued := map[plumbing.Hash]bool{head.Hash: true}
+
+	for len(queue) > 0 {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		default:
+		}
+
+		commit := queue[0]
+		queue = queue[1:]
+
+		contained, err := master.contains(ctx, commit.Hash)
+		if err != nil {
+			return nil, err
+		}
+		if contained {
+			continue
+		}
+		commits = append(commits, commit)
+
+		err = commit.Parents().ForEach(func(parent *object.Commit) error {
+			if !queued[parent.Hash] {
+				queued[parent.Hash] = true
+				queue = append(queue, parent)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walking parents of %s failed: %w", commit.Hash, err)
+		}
+	}
+
+	// Commits found earlier are nearer to head, which breaks ties in dates
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].Committer.When.After(commits[j].Committer.When)
+	})
+
+	return commits, nil
+}
+
+// mergeTarget is a master branch that merges are checked against.
+type mergeTarget struct {
+	name     string
+	commit   *object.Commit
+	ancestry *reachability
+	patches  *patchIndex
+}
+
+// mergeDetector decides whether branches have been merged into one or more
+// masters, applying each enabled strategy in turn. It is safe for concurrent use.
+type mergeDetector struct {
+	repo       *git.Repository
+	index      commitgraph.CommitNodeIndex
+	targets    []*mergeTarget
+	strategies []DetectionStrategy
+	requireAll bool
+	closer     io.Closer
+}
+
+// newMergeDetector returns a detector for the given masters, keyed by name. With
+// requireAll a branch must be contained in every master rather than any of them.
+func newMergeDetector(
+	repo *git.Repository,
+	masters map[string]plumbing.Hash,
+	strategies []DetectionStrategy,
+	requireAll bool,
+) (*mergeDetector, error) {
+	index, closer := openCommitNodeIndex(repo)
+	detector := &mergeDetector{
+		repo:       repo,
+		index:      index,
+		strategies: strategies,
+		requireAll: requireAll,
+		closer:     closer,
+	}
+
+	names := make([]string, 0, len(masters))
+	for name := range masters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		commit, err := repo.CommitObject(masters[name])
+		if err != nil {
+			_ = closer.Close()
+			return nil, fmt.Errorf("loading master commit %s failed: %w", name, err)
+		}
+
+		ancestry, err := newReachability(index, masters[name])
+		if err != nil {
+			_ = closer.Close()
+			return nil, err
+		}
+
+		detector.targets = append(detector.targets, &mergeTarget{
+			name:     name,
+			commit:   commit,
+			ancestry: ancestry,
+			patches:  newPatchIndex(commit),
+		})
+	}
+
+	return detector, nil
+}
+
+// Close releases the commit-graph files used by the detector.
+func (d *mergeDetector) Close() error {
+	return d.closer.Close()
+}
+
+// detect reports whether branch has been merged, by which strategy, and into
+// which masters. The strategy is the one that matched the first master.
+func (d *mergeDetector) detect(ctx context.Context, branch BranchInfo) (MergedBranch, bool, error) {
+	merged := MergedBranch{BranchInfo: branch}
+
+	for _, target := range d.targets {
+		strategy, ok, err := d.detectInTarget(ctx, target, branch)
+		if err != nil {
+			return MergedBranch{}, false, err
+		}
+
+		if !ok {
+			if d.requireAll {
+				LogInfof("Branch %s is not merged into %s, so is not merged into every master", branch.Name, target.name)
+				return MergedBranch{}, false, nil
+			}
+			continue
+		}
+
+		if merged.Strategy == "" {
+			merged.Strategy = strategy
+		}
+		merged.Masters = append(merged.Masters, target.name)
+	}
+
+	return merged, len(merged.Masters) > 0, nil
+}
+
+// unmergedCommits returns how many commits of branch are missing from the master
+// that has the most of them in common, i.e. the fewest commits that would be
+// lost by deleting it.
+func (d *mergeDetector) unmergedCommits(ctx context.Context, branch BranchInfo) (int, error) {
+	head, err := d.repo.CommitObject(branch.Hash)
+	if err != nil {
+		return 0, fmt.Errorf("loading head of %s failed: %w", branch.Name, err)
+	}
+
+	fewest := -1
+	for _, target := range d.targets {
+		commits, err := branchOnlyCommits(ctx, head, target.ancestry)
+		if err != nil {
+			return 0, fmt.Errorf("comparing %s with %s failed: %w", branch.Name, target.name, err)
+		}
+		if fewest < 0 || len(commits) < fewest {
+			fewest = len(commits)
+		}
+	}
+
+	return fewest, nil
+}
+
+// detectInTarget reports whether branch has been merged into target, and by which
+// strategy. The head commit being part of the target always counts; the patch-id
+// strategies are only tried when it is not.
+func (d *mergeDetector) detectInTarget(
+	ctx context.Context,
+	target *mergeTarget,
+	branch BranchInfo,
+) (DetectionStrategy, bool, error) {
+	contained, err := target.ancestry.contains(ctx, branch.Hash)
+	if err != nil {
+		return "", false, fmt.Errorf("checking ancestry of %s failed: %w", branch.Name, err)
+	}
+
+	if contained {
+		LogInfof(
+			"Branch %s head (%s) was found in %s, so has been merged!",
+			branch.Name,
+			branch.Hash.String(),
+			target.name,
+		)
+		return StrategyHash, true, nil
+	}
+
+	if !usesPatchIDs(d.strategies) {
+		return "", false, nil
+	}
+
+	head, err := d.repo.CommitObject(branch.Hash)
+	if err != nil {
+		return "", false, fmt.Errorf("loading head of %s failed: %w", branch.Name, err)
+	}
+
+	bases, err := head.MergeBase(target.commit)
+	if err != nil {
+		return "", false, fmt.Errorf("finding merge-base of %s failed: %w", branch.Name, err)
+	}
+	if len(bases) == 0 {
+		LogInfof("Branch %s shares no history with %s, skipping patch checks", branch.Name, target.name)
+		return "", false, nil
+	}
+	base, err := newReachability(d.index, bases[0].Hash)
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, strategy := range d.strategies {
+		var matched bool
+		switch strategy {
+		case StrategySquash:
+			matched, err = isSquashMerged(ctx, target, branch, head, bases[0], base)
+		case StrategyRebase:
+			matched, err = isRebaseMerged(ctx, target, branch, head, base)
+		case StrategyHash:
+			continue
+		}
+		if err != nil {
+			return "", false, err
+		}
+
+		if matched {
+			return strategy, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// isSquashMerged reports whether the cumulative diff of head since base matches
+// the patch-id of a single commit on the target. ancestry is the reachability of
+// base.
+func isSquashMerged(
+	ctx context.Context,
+	target *mergeTarget,
+	branch BranchInfo,
+	head, base *object.Commit,
+	ancestry *reachability,
+) (bool, error) {
+	id, changed, err := computePatchID(ctx, base, head)
+	if err != nil || !changed {
+		return false, err
+	}
+
+	squashCommit, found, err := target.patches.find(ctx, id, ancestry)
+	if err != nil {
+		return false, fmt.Errorf("looking for squash commit of %s failed: %w", branch.Name, err)
+	}
+
+	if found {
+		LogInfof(
+			"Branch %s was squash-merged into %s as %s, so has been merged!",
+			branch.Name,
+			target.name,
+			squashCommit.Hash.String(),
+		)
+	}
+	return found, nil
+}
+
+// isRebaseMerged reports whether every non-merge commit unique to head has a
+// patch-equivalent commit on the target, made since the merge base whose
+// reachability is base.
+func isRebaseMerged(ctx context.Context, target *mergeTarget, branch BranchInfo, head *object.Commit, base *reachability) (bool, error) {
+	commits, err := branchOnlyCommits(ctx, head, target.ancestry)
+	if err != nil {
+		return false, fmt.Errorf("listing commits of %s failed: %w", branch.Name, err)
+	}
+
+	checked := 0
+	for _, commit := range commits {
+		if commit.NumParents() != 1 {
+			continue
+		}
+
+		parent, err := commit.Parent(0)
+		if err != nil {
+			return false, fmt.Errorf("loading parent of %s failed: %w", commit.Hash, err)
+		}
+
+		id, changed, err := computePatchID(ctx, parent, commit)
+		if err != nil {
+			return false, err
+		}
+		if !changed {
+			continue
+		}
+
+		equivalent, found, err := target.patches.find(ctx, id, base)
+		if err != nil {
+			return false, fmt.Errorf("looking for rebased commits of %s failed: %w", branch.Name, err)
+		}
+		if !found {
+			return false, nil
+		}
+
+		LogInfof(
+			"Commit %s of branch %s was applied to %s as %s",
+			commit.Hash.String(),
+			branch.Name,
+			target.name,
+			equivalent.Hash.String(),
+		)
+		checked++
+	}
+
+	if checked == 0 {
+		return false, nil
+	}
+
+	LogInfof("Every commit of branch %s was rebased onto %s, so has been merged!", branch.Name, target.name)
+	return true, nil
+}
@@ -0,0 +1,304 @@
+package internal
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-billy/v5/memfs"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/object/commitgraph"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testRepo builds commit histories in an in-memory repository.
+type testRepo struct {
+	t     *testing.T
+	repo  *git.Repository
+	wt    *git.Worktree
+	clock time.Time
+	// step is how far the clock moves for each commit, zero giving them all the
+	// same time as scripted merges often have.
+	step time.Duration
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	t.Helper()
+
+	repo, err := git.Init(memory.NewStorage(), memfs.New())
+	require.NoError(t, err)
+
+	wt, err := repo.Worktree()
+	require.NoError(t, err)
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{Name: "origin", URLs: []string{"https://example.com/repo.git"}})
+	require.NoError(t, err)
+
+	return &testRepo{t: t, repo: repo, wt: wt, clock: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), step: time.Hour}
+}
+
+// commit writes files into the worktree and commits them on the current branch.
+func (r *testRepo) commit(msg string, files map[string]string) plumbing.Hash {
+	r.t.Helper()
+
+	for name, content := range files {
+		f, err := r.wt.Filesystem.Create(name)
+		require.NoError(r.t, err)
+		_, err = f.Write([]byte(content))
+		require.NoError(r.t, err)
+		require.NoError(r.t, f.Close())
+
+		_, err = r.wt.Add(name)
+		require.NoError(r.t, err)
+	}
+
+	r.clock = r.clock.Add(r.step)
+	signature := &object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: r.clock}
+	hash, err := r.wt.Commit(msg, &git.CommitOptions{Author: signature, Committer: signature})
+	require.NoError(r.t, err)
+
+	return hash
+}
+
+// checkout switches to branch, creating it at from when from is not zero.
+func (r *testRepo) checkout(branch string, from plumbing.Hash) {
+	r.t.Helper()
+
+	opts := &git.CheckoutOptions{Branch: plumbing.NewBranchReferenceName(branch), Force: true}
+	if !from.IsZero() {
+		opts.Hash = from
+		opts.Create = true
+	}
+	require.NoError(r.t, r.wt.Checkout(opts))
+}
+
+// setRef points the named reference at hash.
+func (r *testRepo) setRef(name string, hash plumbing.Hash) {
+	r.t.Helper()
+
+	require.NoError(r.t, r.repo.Storer.SetReference(plumbing.NewHashReference(plumbing.ReferenceName(name), hash)))
+}
+
+// squashHistory builds a master branch with one squash-merged, one hash-merged and
+// one unmerged remote branch.
+func squashHistory(t *testing.T) *testRepo {
+	t.Helper()
+
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n", "main.go": "package main\n"})
+
+	r.checkout("squashed", base)
+	r.commit("add feature", map[string]string{"feature.go": "package main\n\nfunc feature() {}\n"})
+	squashedHead := r.commit("tweak feature", map[string]string{"feature.go": "package main\n\nfunc feature() int { return 1 }\n"})
+
+	r.checkout("unmerged", base)
+	unmergedHead := r.commit("work in progress", map[string]string{"wip.go": "package main\n"})
+
+	r.checkout("master", plumbing.ZeroHash)
+	r.commit("unrelated change", map[string]string{"README.md": "hello world\n"})
+	r.commit("Add feature (#1)", map[string]string{"feature.go": "package main\n\nfunc feature()   int { return 1 }\n"})
+	mergedHead := r.commit("docs", map[string]string{"docs.md": "docs\n"})
+	r.commit("more docs", map[string]string{"docs.md": "more docs\n"})
+
+	r.setRef("refs/remotes/origin/squashed", squashedHead)
+	r.setRef("refs/remotes/origin/unmerged", unmergedHead)
+	r.setRef("refs/remotes/origin/merged", mergedHead)
+
+	return r
+}
+
+func TestParseDetectionStrategies(t *testing.T) {
+	strategies, err := ParseDetectionStrategies("")
+	require.NoError(t, err)
+	assert.Equal(t, []DetectionStrategy{StrategyHash}, strategies)
+
+	strategies, err = ParseDetectionStrategies(" Squash,hash,rebase,squash")
+	require.NoError(t, err)
+	assert.Equal(t, []DetectionStrategy{StrategyHash, StrategySquash, StrategyRebase}, strategies)
+
+	_, err = ParseDetectionStrategies("octopus")
+	require.EqualError(t, err, `unknown detection strategy "octopus"`)
+}
+
+func TestComputePatchID(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"a.txt": "one\ntwo\n", "b.txt": "x\n"})
+	first := r.commit("change a", map[string]string{"a.txt": "one\n2\n"})
+
+	r.checkout("other", base)
+	moved := r.commit("change b", map[string]string{"b.txt": "y\n"})
+	same := r.commit("change a again", map[string]string{"a.txt": "one\n  2\n"})
+
+	commit := func(h plumbing.Hash) *object.Commit {
+		c, err := r.repo.CommitObject(h)
+		require.NoError(t, err)
+		return c
+	}
+
+	ctx := context.Background()
+	firstID, changed, err := computePatchID(ctx, commit(base), commit(first))
+	require.NoError(t, err)
+	assert.True(t, changed)
+
+	sameID, changed, err := computePatchID(ctx, commit(moved), commit(same))
+	require.NoError(t, err)
+	assert.True(t, changed)
+	assert.Equal(t, firstID, sameID, "whitespace and position must not change the patch-id")
+
+	movedID, _, err := computePatchID(ctx, commit(base), commit(moved))
+	require.NoError(t, err)
+	assert.NotEqual(t, firstID, movedID)
+
+	_, changed, err = computePatchID(ctx, commit(first), commit(first))
+	require.NoError(t, err)
+	assert.False(t, changed)
+}
+
+func TestGetMergedBranches_Squash(t *testing.T) {
+	r := squashHistory(t)
+
+	merged, err := GetMergedBranches(r.repo, MergedBranchesOptions{
+		Remote:     "origin",
+		Masters:    []string{"master"},
+		Strategies: []DetectionStrategy{StrategyHash},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]DetectionStrategy{"origin/merged": StrategyHash}, strategiesByName(merged))
+
+	merged, err = GetMergedBranches(r.repo, MergedBranchesOptions{
+		Remote:     "origin",
+		Masters:    []string{"master"},
+		Strategies: []DetectionStrategy{StrategyHash, StrategySquash},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]DetectionStrategy{
+		"origin/merged":   StrategyHash,
+		"origin/squashed": StrategySquash,
+	}, strategiesByName(merged))
+}
+
+func TestGetMergedBranches_Rebase(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"})
+
+	r.checkout("rebased", base)
+	r.commit("add a", map[string]string{"a.txt": "a\n"})
+	rebasedHead := r.commit("add b", map[string]string{"b.txt": "b\n"})
+
+	r.checkout("partial", base)
+	r.commit("add a", map[string]string{"a.txt": "a\n"})
+	partialHead := r.commit("add c", map[string]string{"c.txt": "c\n"})
+
+	r.checkout("master", plumbing.ZeroHash)
+	r.commit("unrelated change", map[string]string{"README.md": "hello world\n"})
+	r.commit("add a", map[string]string{"a.txt": "a\n"})
+	r.commit("add b", map[string]string{"b.txt": "b\n"})
+
+	r.setRef("refs/remotes/origin/rebased", rebasedHead)
+	r.setRef("refs/remotes/origin/partial", partialHead)
+
+	merged, err := GetMergedBranches(r.repo, MergedBranchesOptions{
+		Remote:     "origin",
+		Masters:    []string{"master"},
+		Strategies: []DetectionStrategy{StrategyHash, StrategySquash, StrategyRebase},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]DetectionStrategy{"origin/rebased": StrategyRebase}, strategiesByName(merged))
+}
+
+func TestGetMergedBranches_RebaseWithEqualTimes(t *testing.T) {
+	// Every commit is made in the same second, as when a script cherry-picks
+	r := newTestRepo(t)
+	r.step = 0
+	r.commit("initial", map[string]string{"README.md": "hello\n"})
+	r.commit("second", map[string]string{"README.md": "hello again\n"})
+	r.commit("third", map[string]string{"README.md": "hello once more\n"})
+	base := r.commit("fourth", map[string]string{"README.md": "hello at last\n"})
+
+	r.checkout("rebased", base)
+	r.commit("add a", map[string]string{"a.txt": "a\n"})
+	rebasedHead := r.commit("add b", map[string]string{"b.txt": "b\n"})
+
+	r.checkout("master", plumbing.ZeroHash)
+	r.commit("unrelated change", map[string]string{"c.txt": "c\n"})
+	r.commit("another change", map[string]string{"c.txt": "cc\n"})
+	r.commit("one more change", map[string]string{"c.txt": "ccc\n"})
+	r.commit("add a", map[string]string{"a.txt": "a\n"})
+	r.commit("add b", map[string]string{"b.txt": "b\n"})
+
+	r.setRef("refs/remotes/origin/rebased", rebasedHead)
+
+	merged, err := GetMergedBranches(r.repo, MergedBranchesOptions{
+		Remote:     "origin",
+		Masters:    []string{"master"},
+		Strategies: []DetectionStrategy{StrategyHash, StrategyRebase},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]DetectionStrategy{"origin/rebased": StrategyRebase}, strategiesByName(merged))
+}
+
+func TestGetMergedBranches_SquashWithSkewedClock(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"})
+
+	r.checkout("squashed", base)
+	r.commit("add a", map[string]string{"a.txt": "a\n"})
+	squashedHead := r.commit("add b", map[string]string{"b.txt": "b\n"})
+
+	// The squash commit is made on a clock running two days behind
+	r.checkout("master", plumbing.ZeroHash)
+	r.clock = r.clock.Add(-48 * time.Hour)
+	r.commit("Squashed feature", map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	r.clock = r.clock.Add(48 * time.Hour)
+	r.commit("later change", map[string]string{"c.txt": "c\n"})
+
+	r.setRef("refs/remotes/origin/squashed", squashedHead)
+
+	merged, err := GetMergedBranches(r.repo, MergedBranchesOptions{
+		Remote:     "origin",
+		Masters:    []string{"master"},
+		Strategies: []DetectionStrategy{StrategySquash, StrategyRebase},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]DetectionStrategy{"origin/squashed": StrategySquash}, strategiesByName(merged))
+}
+
+func TestBranchOnlyCommits(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"})
+
+	r.checkout("feature", base)
+	first := r.commit("one", map[string]string{"a.txt": "1\n"})
+	second := r.commit("two", map[string]string{"a.txt": "2\n"})
+
+	r.checkout("master", plumbing.ZeroHash)
+	masterHead := r.commit("master work", map[string]string{"b.txt": "b\n"})
+
+	head, err := r.repo.CommitObject(second)
+	require.NoError(t, err)
+	master, err := newReachability(commitgraph.NewObjectCommitNodeIndex(r.repo.Storer), masterHead)
+	require.NoError(t, err)
+
+	commits, err := branchOnlyCommits(context.Background(), head, master)
+	require.NoError(t, err)
+
+	hashes := make([]plumbing.Hash, len(commits))
+	for i, c := range commits {
+		hashes[i] = c.Hash
+	}
+	assert.Equal(t, []plumbing.Hash{second, first}, hashes)
+}
+
+// strategiesByName maps each merged branch name to the strategy that matched it.
+func strategiesByName(branches []MergedBranch) map[string]DetectionStrategy {
+	result := make(map[string]DetectionStrategy, len(branches))
+	for _, b := range branches {
+		result[b.Name] = b.Strategy
+	}
+	return result
+}
@@ -0,0 +1,163 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+const (
+	// DeleteTimeout bounds a single `git push` deleting or restoring branches.
+	DeleteTimeout = 30 * time.Second
+	// DeleteBatchSize caps the branches deleted by a single `git push`, keeping
+	// the command line and the update sent to the remote to a reasonable size.
+	DeleteBatchSize = 100
+	// FetchTimeout bounds the `git fetch` run before looking for merged branches.
+	FetchTimeout = 5 * time.Minute
+)
+
+// Porcelain flags of `git push` for refs it deleted, created, or found already
+// at the pushed commit.
+const (
+	pushDeleted  = '-'
+	pushCreated  = '*'
+	pushUpToDate = '='
+)
+
+// pushStatus is the outcome of pushing one ref, from `git push --porcelain`.
+type pushStatus struct {
+	// Flag is a single character: ' ' fast-forward, '+' forced update, '-'
+	// deleted, '*' new ref, '!' rejected or failed, '=' up to date.
+	Flag byte
+	// Summary describes the outcome, such as "[deleted]" or
+	// "[remote rejected] (protected branch hook declined)".
+	Summary string
+}
+
+// errGitTimeout is wrapped by runGit when a git command exceeds its timeout.
+var errGitTimeout = errors.New("git command timed out")
+
+// runGit runs the system git with args in the repository's directory and returns
+// its trimmed combined output, which is also returned alongside any error.
+//
+// GIT_TERMINAL_PROMPT=0 is set so that commands fail cleanly rather than waiting
+// for credentials in non-interactive contexts. Errors caused by the timeout wrap
+// errGitTimeout.
+func runGit(repo *git.Repository, timeout time.Duration, args ...string) (string, error) {
+	// Verify git is available
+	gitPath, err := exec.LookPath("git")
+	if err != nil {
+		return "", fmt.Errorf("git command not found in PATH: %w", err)
+	}
+
+	repoPath, err := repoDir(repo)
+	if err != nil {
+		return "", err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// gitPath is validated via exec.LookPath and args are passed as separate
+	// arguments (not shell interpolation), making this safe from injection
+	//nolint:gosec // validated inputs, no shell interpolation
+	cmd := exec.CommandContext(ctx, gitPath, args...)
+	cmd.Dir = repoPath
+	// Set non-interactive environment to fail cleanly in non-interactive contexts
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+
+	output, err := cmd.CombinedOutput()
+	trimmedOutput := strings.TrimSpace(string(output))
+
+	if err != nil && errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return trimmedOutput, fmt.Errorf("%w after %s: %w", errGitTimeout, timeout, err)
+	}
+
+	return trimmedOutput, err
+}
+
+// repoDir returns the directory git commands for repo should run in: the root of
+// the worktree, or the repository itself when it is bare.
+func repoDir(repo *git.Repository) (string, error) {
+	worktree, err := repo.Worktree()
+	if err == nil {
+		return worktree.Filesystem.Root(), nil
+	}
+
+	if storage, ok := repo.Storer.(*filesystem.Storage); ok && errors.Is(err, git.ErrIsBareRepository) {
+		return storage.Filesystem().Root(), nil
+	}
+
+	return "", fmt.Errorf("failed to get worktree: %w", err)
+}
+
+// FetchRemote updates the remote-tracking branches of remote by running
+// `git fetch --prune <remote>`, so branches deleted on the remote are dropped
+// before looking for merged ones. Like DeleteBranch it uses the system git, so
+// the user's credentials and SSH setup apply.
+func FetchRemote(repo *git.Repository, remote string) error {
+	if err := checkRemoteExists(repo, remote); err != nil {
+		return err
+	}
+
+	output, err := runGit(repo, FetchTimeout, "fetch", "--prune", "--", remote)
+	if err != nil {
+		return fmt.Errorf("failed to fetch from remote %s: %w\nOutput: %s", remote, err, output)
+	}
+
+	LogInfof("Fetched from remote %s", remote)
+	return nil
+}
+
+// pushRefs runs `git push --porcelain` to remote with the given refspecs, each
+// lease being passed as --force-with-lease=<lease>, and returns the status git
+// reported for each remote ref along with its output. Pushes are not atomic, so
+// some refs may have been updated even when an error is returned.
+func pushRefs(
+	repo *git.Repository,
+	remote string,
+	refspecs, leases []string,
+) (map[plumbing.ReferenceName]pushStatus, string, error) {
+	args := []string{"push", "--porcelain"}
+	for _, lease := range leases {
+		args = append(args, "--force-with-lease="+lease)
+	}
+	args = append(append(args, remote), refspecs...)
+
+	output, err := runGit(repo, DeleteTimeout, args...)
+	return parsePushPorcelain(output), output, err
+}
+
+// parsePushPorcelain reads the per-ref status lines of `git push --porcelain`,
+// which have the form "<flag>\t<from>:<to>\t<summary>", keyed by the remote ref.
+// Other lines, such as "To <url>" and messages from the remote, are ignored.
+func parsePushPorcelain(output string) map[plumbing.ReferenceName]pushStatus {
+	statuses := make(map[plumbing.ReferenceName]pushStatus)
+
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.SplitN(line, "\t", 3)
+		if len(fields) != 3 || len(fields[0]) != 1 {
+			continue
+		}
+
+		colon := strings.LastIndex(fields[1], ":")
+		if colon < 0 {
+			continue
+		}
+
+		statuses[plumbing.ReferenceName(fields[1][colon+1:])] = pushStatus{
+			Flag:    fields[0][0],
+			Summary: strings.TrimSpace(fields[2]),
+		}
+	}
+
+	return statuses
+}
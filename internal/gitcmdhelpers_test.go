@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// diskRepo is a clone on disk of a bare repository, both created in a temporary
+// directory, for tests that need the system git.
+type diskRepo struct {
+	t      *testing.T
+	repo   *git.Repository
+	origin *git.Repository
+}
+
+func newDiskRepo(t *testing.T) *diskRepo {
+	t.Helper()
+
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	origin, err := git.PlainInit(filepath.Join(dir, "origin.git"), true)
+	require.NoError(t, err)
+
+	repo, err := git.PlainInit(filepath.Join(dir, "clone"), false)
+	require.NoError(t, err)
+
+	_, err = repo.CreateRemote(&config.RemoteConfig{
+		Name:  "origin",
+		URLs:  []string{filepath.Join(dir, "origin.git")},
+		Fetch: []config.RefSpec{"+refs/heads/*:refs/remotes/origin/*"},
+	})
+	require.NoError(t, err)
+
+	return &diskRepo{t: t, repo: repo, origin: origin}
+}
+
+// commit creates an empty commit in the clone and returns its hash.
+func (d *diskRepo) commit(msg string) plumbing.Hash {
+	d.t.Helper()
+
+	wt, err := d.repo.Worktree()
+	require.NoError(d.t, err)
+
+	hash, err := wt.Commit(msg, &git.CommitOptions{AllowEmptyCommits: true, Author: testSignature()})
+	require.NoError(d.t, err)
+	return hash
+}
+
+// push pushes hash to the named branches on the origin repository.
+func (d *diskRepo) push(hash plumbing.Hash, branches ...string) {
+	d.t.Helper()
+
+	for _, branch := range branches {
+		refspec := config.RefSpec(hash.String() + ":refs/heads/" + branch)
+		require.NoError(d.t, d.repo.Push(&git.PushOptions{RemoteName: "origin", RefSpecs: []config.RefSpec{refspec}}))
+	}
+}
+
+// testSignature returns a fixed author for commits made in tests.
+func testSignature() *object.Signature {
+	return &object.Signature{Name: "Jane Doe", Email: "jane@example.com", When: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func TestFetchRemote(t *testing.T) {
+	d := newDiskRepo(t)
+	hash := d.commit("initial")
+	d.push(hash, "master", "feature")
+
+	// A remote-tracking branch whose branch no longer exists on the remote
+	require.NoError(t, d.repo.Storer.SetReference(
+		plumbing.NewHashReference(plumbing.NewRemoteReferenceName("origin", "stale"), hash)))
+
+	require.NoError(t, FetchRemote(d.repo, "origin"))
+
+	ref, err := d.repo.Reference(plumbing.NewRemoteReferenceName("origin", "feature"), true)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+
+	_, err = d.repo.Reference(plumbing.NewRemoteReferenceName("origin", "stale"), true)
+	require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+}
+
+func TestFetchRemote_Failures(t *testing.T) {
+	d := newDiskRepo(t)
+
+	require.EqualError(t, FetchRemote(d.repo, "upstream"), "Could not find the remote named upstream")
+
+	_, err := d.repo.CreateRemote(&config.RemoteConfig{Name: "broken", URLs: []string{"/nonexistent/repo.git"}})
+	require.NoError(t, err)
+
+	err = FetchRemote(d.repo, "broken")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to fetch from remote broken")
+}
+
+func TestParsePushPorcelain(t *testing.T) {
+	output := "remote: warning: deleting a non-existent ref\n" +
+		"To github.com:example/repo.git\n" +
+		"-\t:refs/heads/feature\t[deleted]\n" +
+		"!\t:refs/heads/main\t[remote rejected] (protected branch hook declined)\n" +
+		" \trefs/heads/dev:refs/heads/dev\t1a2b3c4..5d6e7f8\n" +
+		"Done"
+
+	assert.Equal(t, map[plumbing.ReferenceName]pushStatus{
+		"refs/heads/feature": {Flag: '-', Summary: "[deleted]"},
+		"refs/heads/main":    {Flag: '!', Summary: "[remote rejected] (protected branch hook declined)"},
+		"refs/heads/dev":     {Flag: ' ', Summary: "1a2b3c4..5d6e7f8"},
+	}, parsePushPorcelain(output))
+}
+
+func TestDeleteBranches(t *testing.T) {
+	d := newDiskRepo(t)
+	hash := d.commit("initial")
+	d.push(hash, "master", "feature-a", "feature-b", "protected")
+
+	// Reject deleting one of the branches, like a protected branch on a hosted remote
+	storage, ok := d.origin.Storer.(*filesystem.Storage)
+	require.True(t, ok)
+	hook := "#!/bin/sh\n[ \"$1\" = refs/heads/protected ] && echo 'protected branch' >&2 && exit 1\nexit 0\n"
+	hooks := filepath.Join(storage.Filesystem().Root(), "hooks")
+	require.NoError(t, os.MkdirAll(hooks, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(hooks, "update"), []byte(hook), 0o755))
+
+	errs := DeleteBranches(context.Background(), d.repo, "origin", []BranchInfo{
+		{Short: "feature-a", Hash: hash},
+		{Short: "protected", Hash: hash},
+		{Short: ""},
+		{Short: "feature-b"},
+	}, DeleteOptions{})
+	require.Len(t, errs, 4)
+	require.NoError(t, errs[0])
+	require.Error(t, errs[1])
+	assert.Contains(t, errs[1].Error(), "failed to delete branch protected on remote origin: [remote rejected]")
+	require.EqualError(t, errs[2], "branch name cannot be empty")
+	require.NoError(t, errs[3])
+
+	for branch, exists := range map[string]bool{"feature-a": false, "feature-b": false, "protected": true, "master": true} {
+		_, err := d.origin.Reference(plumbing.NewBranchReferenceName(branch), false)
+		assert.Equal(t, exists, err == nil, "branch %s", branch)
+	}
+
+	require.EqualError(t, DeleteBranch(d.repo, "", "feature-a", hash), "remote name cannot be empty")
+}
+
+func TestDeleteBranches_Jobs(t *testing.T) {
+	d := newDiskRepo(t)
+	hash := d.commit("initial")
+
+	var branches []BranchInfo
+	for i := 0; i < 7; i++ {
+		branches = append(branches, BranchInfo{Short: fmt.Sprintf("feature-%d", i), Hash: hash})
+		d.push(hash, branches[i].Short)
+	}
+
+	errs := DeleteBranches(context.Background(), d.repo, "origin", branches, DeleteOptions{BatchSize: 2, Jobs: 3})
+	require.Len(t, errs, len(branches))
+	for i, branch := range branches {
+		require.NoError(t, errs[i], branch.Short)
+
+		_, err := d.origin.Reference(plumbing.NewBranchReferenceName(branch.Short), false)
+		require.ErrorIs(t, err, plumbing.ErrReferenceNotFound, branch.Short)
+	}
+}
+
+func TestDeleteBranches_Cancelled(t *testing.T) {
+	d := newDiskRepo(t)
+	hash := d.commit("initial")
+	d.push(hash, "feature")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	errs := DeleteBranches(ctx, d.repo, "origin", []BranchInfo{{Short: "feature", Hash: hash}}, DeleteOptions{})
+	require.ErrorIs(t, errs[0], context.Canceled)
+
+	_, err := d.origin.Reference(plumbing.NewBranchReferenceName("feature"), false)
+	require.NoError(t, err)
+}
+
+func TestDeleteBranches_Deleted(t *testing.T) {
+	d := newDiskRepo(t)
+	hash := d.commit("initial")
+
+	var branches []BranchInfo
+	for i := 0; i < 3; i++ {
+		branches = append(branches, BranchInfo{Short: fmt.Sprintf("feature-%d", i), Hash: hash})
+		d.push(hash, branches[i].Short)
+	}
+
+	// The first batch cannot be recorded, so the others are never pushed
+	var reported [][]BranchInfo
+	errs := DeleteBranches(context.Background(), d.repo, "origin", branches, DeleteOptions{
+		BatchSize: 1,
+		Deleted: func(deleted []BranchInfo) error {
+			reported = append(reported, deleted)
+			return errors.New("journal is full")
+		},
+	})
+	require.Equal(t, [][]BranchInfo{{branches[0]}}, reported)
+	require.NoError(t, errs[0])
+	require.EqualError(t, errs[1], "not deleting branch feature-1: journal is full")
+	require.EqualError(t, errs[2], "not deleting branch feature-2: journal is full")
+
+	_, err := d.origin.Reference(plumbing.NewBranchReferenceName("feature-2"), false)
+	require.NoError(t, err)
+}
+
+func TestDeleteBranches_Moved(t *testing.T) {
+	d := newDiskRepo(t)
+	listed := d.commit("initial")
+	d.push(listed, "master", "feature", "stale")
+
+	// Someone pushes to the branch after it was listed
+	moved := d.commit("new work")
+	d.push(moved, "feature")
+
+	errs := DeleteBranches(context.Background(), d.repo, "origin", []BranchInfo{
+		{Short: "feature", Hash: listed},
+		{Short: "stale", Hash: listed},
+	}, DeleteOptions{})
+	require.ErrorIs(t, errs[0], ErrBranchMoved)
+	assert.Contains(t, errs[0].Error(), "branch feature on remote origin is no longer at")
+	require.NoError(t, errs[1])
+
+	ref, err := d.origin.Reference(plumbing.NewBranchReferenceName("feature"), false)
+	require.NoError(t, err)
+	assert.Equal(t, moved, ref.Hash())
+}
@@ -5,7 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
-	"os/exec"
+	"path"
 	"runtime"
 	"sort"
 	"strings"
@@ -14,17 +14,15 @@ import (
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
-	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing/storer"
 )
 
 const (
-	// MaxCommitsToCheck limits how many commits to check for merged branches.
-	MaxCommitsToCheck = 10000
 	// ConcurrentWorkers defines how many goroutines to use for concurrent processing.
 	ConcurrentWorkers = 4
-	// BatchSize for processing commits in batches.
-	BatchSize = 100
+	// DefaultMasterCandidates lists the branch names tried, in order, when the remote
+	// does not say which branch is its default.
+	DefaultMasterCandidates = "main,master,develop"
 )
 
 // BranchInfo holds branch information.
@@ -35,10 +33,33 @@ type BranchInfo struct {
 	Short  string
 }
 
-// commitBatch represents a batch of commits to process.
-type commitBatch struct {
-	commits  []*object.Commit
-	startIdx int
+// MergedBranchesOptions controls how GetMergedBranches looks for merged branches.
+type MergedBranchesOptions struct {
+	// Remote is the name of the remote whose branches are checked.
+	Remote string
+	// Masters lists the branches merges are checked against. Entries may be glob
+	// patterns such as "release/*", matched against the remote's branches.
+	Masters []string
+	// RequireAll only counts a branch as merged when every master contains it,
+	// rather than any of them.
+	RequireAll bool
+	// PreferLocal resolves masters from refs/heads before refs/remotes/<Remote>.
+	PreferLocal bool
+	// Local checks the local branches in refs/heads instead of the remote's branches.
+	Local bool
+	// Skip is a comma-separated list of branches to leave alone, given as names
+	// or patterns, see ParseBranchPatterns.
+	Skip string
+	// Only is a comma-separated list of branch names or patterns; when given, the
+	// branches matching none of them are left alone.
+	Only string
+	// Strategies lists the detection strategies to apply, see ParseDetectionStrategies.
+	Strategies []DetectionStrategy
+	// Age leaves out the branches it does not keep, before looking at merges.
+	Age AgeFilter
+	// Protected names branches that are never offered for deletion, as the
+	// masters are not, such as the candidates a master was detected among.
+	Protected []string
 }
 
 // RemoteBranches returns an iterator over all remote branch references in the repository.
@@ -69,8 +90,43 @@ func ParseBranchName(s string) (remote, branch string) {
 	return s, ""
 }
 
-// DeleteBranch deletes the named branch from the given remote by invoking
-// `git push <remote> --delete <branchShortName>`.
+// DeleteOptions controls how DeleteBranches spreads deletions over pushes.
+type DeleteOptions struct {
+	// BatchSize caps the branches deleted by a single `git push`, defaulting to
+	// DeleteBatchSize. A size of 1 pushes every branch on its own, for remotes
+	// that reject large pushes.
+	BatchSize int
+	// Jobs is how many pushes may run at once, defaulting to one.
+	Jobs int
+	// ArchivePrefix, when set, is a ref namespace such as "refs/archive/" that
+	// each branch head is pushed under before the branch is deleted, see
+	// ParseArchivePrefix. A branch that cannot be archived is not deleted.
+	ArchivePrefix string
+	// Deleted, when set, is called with the branches of each batch that were
+	// deleted as soon as the batch is pushed, one call at a time. An error stops
+	// further pushes from starting, as cancelling does.
+	Deleted func(deleted []BranchInfo) error
+}
+
+// ErrBranchMoved is wrapped by the errors of DeleteBranches for branches that no
+// longer point at the commit they were expected to, so were left alone.
+var ErrBranchMoved = errors.New("branch moved")
+
+// DeleteBranch deletes the named branch from the given remote, provided it still
+// points at expected. It is DeleteBranches for a single branch.
+func DeleteBranch(repo *git.Repository, remote, branchShortName string, expected plumbing.Hash) error {
+	branch := BranchInfo{Name: remote + "/" + branchShortName, Hash: expected, Remote: remote, Short: branchShortName}
+	return DeleteBranches(context.Background(), repo, remote, []BranchInfo{branch}, DeleteOptions{})[0]
+}
+
+// DeleteBranches deletes the branches, by their Short name, from the given remote
+// by invoking `git push --porcelain <remote> :refs/heads/<branch>...`, and returns
+// one error per branch, in the order given. A nil error means the branch was
+// deleted.
+//
+// Each deletion is guarded by `--force-with-lease=refs/heads/<branch>:<Hash>`, so
+// a branch someone pushed to since it was listed is not deleted; its error wraps
+// ErrBranchMoved. Branches with a zero Hash are deleted unconditionally.
 //
 // We shell out to git instead of using go-git's push operations to avoid complex
 // authentication handling. The go-git library has significant limitations with various
@@ -79,57 +135,200 @@ func ParseBranchName(s string) (remote, branch string) {
 // existing authentication configuration automatically.
 // See: https://github.com/go-git/go-git/issues/28
 //
-// The function validates inputs (non-empty remote and branchShortName, branchShortName
-// must not start with '-'), verifies git is available, sets GIT_TERMINAL_PROMPT=0 for
-// non-interactive contexts, and runs with a 30-second timeout. Returns a timeout-specific
-// error if context deadline is exceeded, otherwise returns an error containing the
-// trimmed command output for diagnostics.
-func DeleteBranch(repo *git.Repository, remote, branchShortName string) error {
+// Inputs are validated per branch (non-empty remote and branch name, branch name
+// must not start with '-'). The branches are split into batches of
+// opts.BatchSize, pushed by up to opts.Jobs workers. Each push runs with a
+// 30-second timeout, and since pushes are not atomic, the porcelain output is
+// parsed for the status of every ref: a branch the remote rejects fails on its
+// own without affecting the others. Branches git reports nothing for get a
+// timeout-specific error if the deadline was exceeded, otherwise an error
+// containing the trimmed command output.
+//
+// Cancelling ctx stops further pushes from starting; pushes already running are
+// left to finish, and the branches never pushed fail with the context's error,
+// or with the error opts.Deleted returned.
+func DeleteBranches(
+	ctx context.Context,
+	repo *git.Repository,
+	remote string,
+	branches []BranchInfo,
+	opts DeleteOptions,
+) []error {
+	errs := make([]error, len(branches))
+
 	// Validate inputs
-	if remote == "" {
-		return errors.New("remote name cannot be empty")
+	var pending []int
+	for i, branch := range branches {
+		switch {
+		case remote == "":
+			errs[i] = errors.New("remote name cannot be empty")
+		case branch.Short == "":
+			errs[i] = errors.New("branch name cannot be empty")
+		case strings.HasPrefix(branch.Short, "-"):
+			errs[i] = fmt.Errorf("branch name cannot start with '-': %s", branch.Short)
+		default:
+			pending = append(pending, i)
+		}
 	}
-	if branchShortName == "" {
-		return errors.New("branch name cannot be empty")
+
+	batchSize := opts.BatchSize
+	if batchSize <= 0 {
+		batchSize = DeleteBatchSize
 	}
-	if strings.HasPrefix(branchShortName, "-") {
-		return fmt.Errorf("branch name cannot start with '-': %s", branchShortName)
+	var batches [][]int
+	for start := 0; start < len(pending); start += batchSize {
+		batches = append(batches, pending[start:minInt(start+batchSize, len(pending))])
 	}
 
-	// Verify git is available
-	gitPath, err := exec.LookPath("git")
-	if err != nil {
-		return fmt.Errorf("git command not found in PATH: %w", err)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	jobs := make(chan []int)
+	var wg sync.WaitGroup
+	var reporting sync.Mutex
+	numWorkers := minInt(maxInt(opts.Jobs, 1), len(batches))
+
+	// Each worker writes the errors of its own batches only
+	for i := 0; i < numWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range jobs {
+				// A batch may be received just as the pushes are stopped
+				if ctx.Err() != nil {
+					notDeleted(branches, batch, errs, context.Cause(ctx))
+					continue
+				}
+				if opts.ArchivePrefix != "" {
+					batch = archiveBatch(repo, remote, branches, batch, opts.ArchivePrefix, errs)
+				}
+				deleteBatch(repo, remote, branches, batch, errs)
+				if opts.Deleted != nil {
+					reporting.Lock()
+					if err := opts.Deleted(deletedIn(branches, batch, errs)); err != nil {
+						cancel(err)
+					}
+					reporting.Unlock()
+				}
+			}
+		}()
 	}
 
-	worktree, err := repo.Worktree()
-	if err != nil {
-		return fmt.Errorf("failed to get worktree: %w", err)
+	// Feed batches to the workers until done or cancelled
+	fed := 0
+feed:
+	for ; fed < len(batches) && ctx.Err() == nil; fed++ {
+		select {
+		case jobs <- batches[fed]:
+		case <-ctx.Done():
+			break feed
+		}
 	}
-	repoPath := worktree.Filesystem.Root()
+	close(jobs)
+	wg.Wait()
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-	defer cancel()
+	for _, skipped := range batches[fed:] {
+		notDeleted(branches, skipped, errs, context.Cause(ctx))
+	}
+	return errs
+}
 
-	// gitPath is validated via exec.LookPath, remote and branchShortName are validated inputs
-	// passed as separate arguments (not shell interpolation), making this safe from injection
-	//nolint:gosec // validated inputs, no shell interpolation
-	cmd := exec.CommandContext(ctx, gitPath, "push", remote, "--delete", branchShortName)
-	cmd.Dir = repoPath
-	// Set non-interactive environment to fail cleanly in non-interactive contexts
-	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+// notDeleted fails the branches at the batch's indexes with cause, as they were
+// never pushed.
+func notDeleted(branches []BranchInfo, batch []int, errs []error, cause error) {
+	for _, i := range batch {
+		errs[i] = fmt.Errorf("not deleting branch %s: %w", branches[i].Short, cause)
+	}
+}
 
-	output, err := cmd.CombinedOutput()
-	trimmedOutput := strings.TrimSpace(string(output))
+// deletedIn returns the branches at the batch's indexes whose deletion has no
+// error.
+func deletedIn(branches []BranchInfo, batch []int, errs []error) []BranchInfo {
+	var deleted []BranchInfo
+	for _, i := range batch {
+		if errs[i] == nil {
+			deleted = append(deleted, branches[i])
+		}
+	}
+	return deleted
+}
 
-	if err != nil {
-		// Check for timeout specifically
-		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
-			return fmt.Errorf("timeout deleting branch %s on remote %s after 30s: %w\nOutput: %s",
-				branchShortName, remote, err, trimmedOutput)
+// deleteBatch pushes the deletion of the branches at the batch's indexes into
+// branches, storing the outcome of each at the same index of errs.
+func deleteBatch(repo *git.Repository, remote string, branches []BranchInfo, batch []int, errs []error) {
+	// Without refspecs, git would push the default branches instead
+	if len(batch) == 0 {
+		return
+	}
+
+	var refspecs, leases []string
+	for _, i := range batch {
+		refName := plumbing.NewBranchReferenceName(branches[i].Short)
+		if !branches[i].Hash.IsZero() {
+			leases = append(leases, fmt.Sprintf("%s:%s", refName, branches[i].Hash))
 		}
-		return fmt.Errorf("failed to delete branch %s on remote %s: %w\nOutput: %s",
-			branchShortName, remote, err, trimmedOutput)
+		refspecs = append(refspecs, ":"+refName.String())
+	}
+
+	LogInfof("Deleting %d branches from remote %s", len(batch), remote)
+	statuses, output, err := pushRefs(repo, remote, refspecs, leases)
+
+	for _, i := range batch {
+		status, ok := statuses[plumbing.NewBranchReferenceName(branches[i].Short)]
+		errs[i] = deleteBranchError(remote, branches[i], status, ok, err, output)
+	}
+}
+
+// deleteBranchError turns the outcome of pushing the deletion of a branch into
+// an error, or nil when git reported the branch as deleted.
+func deleteBranchError(remote string, branch BranchInfo, status pushStatus, reported bool, err error, output string) error {
+	switch {
+	case reported && status.Flag == pushDeleted:
+		return nil
+	case reported && strings.Contains(status.Summary, "(stale info)"):
+		return fmt.Errorf("%w: branch %s on remote %s is no longer at %s",
+			ErrBranchMoved, branch.Short, remote, branch.Hash.String()[:7])
+	case reported:
+		return fmt.Errorf("failed to delete branch %s on remote %s: %s", branch.Short, remote, status.Summary)
+	case errors.Is(err, errGitTimeout):
+		return fmt.Errorf("timeout deleting branch %s on remote %s after %s: %w\nOutput: %s",
+			branch.Short, remote, DeleteTimeout, err, output)
+	case err == nil:
+		err = errors.New("git push reported no status for the branch")
+	}
+
+	return fmt.Errorf("failed to delete branch %s on remote %s: %w\nOutput: %s",
+		branch.Short, remote, err, output)
+}
+
+// DeleteLocalBranch deletes the local branch named branchShortName, along with
+// its configuration section, like `git branch -D`. The reference is removed
+// through go-git, as no remote is involved. Branches checked out in any worktree
+// are refused.
+func DeleteLocalBranch(repo *git.Repository, branchShortName string) error {
+	if branchShortName == "" {
+		return errors.New("branch name cannot be empty")
+	}
+
+	checkedOut, err := checkedOutBranches(repo)
+	if err != nil {
+		return err
+	}
+	if worktree, ok := checkedOut[branchShortName]; ok {
+		return fmt.Errorf("branch %s is checked out in worktree %s", branchShortName, worktree)
+	}
+
+	refName := plumbing.NewBranchReferenceName(branchShortName)
+	if _, err := repo.Reference(refName, false); err != nil {
+		return fmt.Errorf("failed to find local branch %s: %w", branchShortName, err)
+	}
+
+	if err := repo.Storer.RemoveReference(refName); err != nil {
+		return fmt.Errorf("failed to delete local branch %s: %w", branchShortName, err)
+	}
+
+	if err := repo.DeleteBranch(branchShortName); err != nil && !errors.Is(err, git.ErrBranchNotFound) {
+		return fmt.Errorf("failed to remove configuration of branch %s: %w", branchShortName, err)
 	}
 
 	return nil
@@ -154,7 +353,8 @@ func GetCurrentDirAsGitRepo() (*git.Repository, error) {
 
 	LogInfof("Attempting to open Git directory at %s", dir)
 
-	repo, err := git.PlainOpen(dir)
+	// Linked worktrees keep refs and objects in the main repository's .git
+	repo, err := git.PlainOpenWithOptions(dir, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
 	if err != nil {
 		return nil, err
 	}
@@ -163,103 +363,313 @@ func GetCurrentDirAsGitRepo() (*git.Repository, error) {
 }
 
 // GetMergedBranches finds branches that have been merged into the master branch.
-func GetMergedBranches(repo *git.Repository, remoteOrigin, masterBranchName, skipBranches string) ([]string, error) {
-	// Convert skip branches to a set for O(1) lookups
-	var skipSet map[string]bool
-	if skipBranches != "" {
-		skipSet = StringSliceToSet(strings.Split(skipBranches, ","))
+func GetMergedBranches(repo *git.Repository, opts MergedBranchesOptions) ([]MergedBranch, error) {
+	filter, err := newBranchFilter(opts.Skip, opts.Only)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate remote exists
+	if err := checkRemoteExists(repo, opts.Remote); err != nil {
+		return nil, err
+	}
+
+	// Check branches with context and timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	masterNames, masterHashes, err := resolveMasters(repo, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	ages, err := newBranchAges(ctx, repo, opts.Age, masterHashes)
+	if err != nil {
+		return nil, err
+	}
+	defer ages.Close()
+
+	// Get the branches to check
+	kept := append(append([]string{}, masterNames...), opts.Protected...)
+	var branches []BranchInfo
+	if opts.Local {
+		branches, err = getLocalBranches(repo, kept, filter, ages)
 	} else {
-		skipSet = make(map[string]bool)
+		branches, err = getRemoteBranches(repo, opts.Remote, kept, filter, ages)
+	}
+	if err != nil {
+		return nil, err
 	}
 
-	LogInfo("Attempting to get master information from branches from repo")
+	// Early exit if no branches to check
+	if len(branches) == 0 {
+		LogInfo("No branches found to check")
+		return []MergedBranch{}, nil
+	}
+
+	if opts.Local {
+		LogInfof("Checking %d local branches", len(branches))
+	} else {
+		LogInfof("Origin has been set to '%s', checking %d branches", opts.Remote, len(branches))
+	}
 
-	// Get branch heads
-	branchHeads, err := getBranchHeads(repo)
+	detector, err := newMergeDetector(repo, masterHashes, opts.Strategies, opts.RequireAll)
 	if err != nil {
 		return nil, err
 	}
+	defer detector.Close()
 
-	fmt.Println("Fetching from the remote...")
+	// Use concurrent processing for large branch sets, sequential for smaller ones
+	var mergedBranches []MergedBranch
+	if len(branches) > 10 {
+		mergedBranches, err = findMergedBranchesConcurrent(ctx, detector, branches)
+	} else {
+		mergedBranches, err = findMergedBranchesSequential(ctx, detector, branches)
+	}
+	if err != nil || !opts.Local {
+		return mergedBranches, err
+	}
 
-	// Validate remote exists
+	if err = markCheckedOut(repo, mergedBranches); err != nil {
+		return nil, err
+	}
+	return mergedBranches, nil
+}
+
+// markCheckedOut sets CheckedOutIn on the branches checked out in a worktree.
+func markCheckedOut(repo *git.Repository, branches []MergedBranch) error {
+	checkedOut, err := checkedOutBranches(repo)
+	if err != nil {
+		return err
+	}
+
+	for i := range branches {
+		branches[i].CheckedOutIn = checkedOut[branches[i].Short]
+	}
+	return nil
+}
+
+// checkRemoteExists returns an error unless the repository has a remote named remoteOrigin.
+func checkRemoteExists(repo *git.Repository, remoteOrigin string) error {
 	listRemotes, err := repo.Remotes()
 	if err != nil {
 		LogFatalError("Error looking for remotes", err)
-		return nil, err
+		return err
 	}
 
 	remoteBranchesAsStrings := RemoteBranchesToStrings(listRemotes)
 	if !IsStringInSet(remoteOrigin, StringSliceToSet(remoteBranchesAsStrings)) {
-		return nil, errors.New("Could not find the remote named " + remoteOrigin)
+		return errors.New("Could not find the remote named " + remoteOrigin)
 	}
 
-	// Get master commits with context and timeout
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
-	defer cancel()
+	return nil
+}
 
-	masterHash, exists := branchHeads[masterBranchName]
-	if !exists {
-		return nil, fmt.Errorf("master branch %s not found", masterBranchName)
-	}
+// resolveMasters expands the masters in opts and resolves each of them to the
+// commit it points at, returning the sorted names alongside the hashes.
+func resolveMasters(repo *git.Repository, opts MergedBranchesOptions) ([]string, map[string]plumbing.Hash, error) {
+	LogInfo("Attempting to get master information from branches from repo")
 
-	masterCommits, err := repo.Log(&git.LogOptions{From: masterHash})
+	masterNames, err := expandMasters(repo, opts.Remote, opts.Masters, opts.PreferLocal)
 	if err != nil {
-		LogFatalError("get commits from master failed:", err)
-		return nil, err
+		return nil, nil, err
 	}
 
-	// Get remote branches
-	remoteBranches, err := getRemoteBranches(repo, remoteOrigin, masterBranchName, skipSet)
-	if err != nil {
-		return nil, err
+	masterHashes := make(map[string]plumbing.Hash, len(masterNames))
+	for _, name := range masterNames {
+		masterHashes[name], err = resolveMasterHash(repo, opts.Remote, name, opts.PreferLocal)
+		if err != nil {
+			return nil, nil, err
+		}
 	}
 
-	// Early exit if no branches to check
-	if len(remoteBranches) == 0 {
-		LogInfo("No branches found for the specified origin")
-		return []string{}, nil
+	return masterNames, masterHashes, nil
+}
+
+// DetectMasterBranch returns the name of the default branch of remoteOrigin.
+//
+// The symbolic ref refs/remotes/<remote>/HEAD, written by `git clone` and
+// `git remote set-head`, is used when present. Otherwise the first of candidates
+// that exists on the remote is returned, or failing that the first that exists
+// locally, so that a local branch never wins over one of the remote.
+func DetectMasterBranch(repo *git.Repository, remoteOrigin string, candidates []string) (string, error) {
+	if err := checkRemoteExists(repo, remoteOrigin); err != nil {
+		return "", err
+	}
+
+	headRef, err := repo.Reference(plumbing.NewRemoteHEADReferenceName(remoteOrigin), false)
+	if err == nil && headRef.Type() == plumbing.SymbolicReference {
+		prefix := fmt.Sprintf("refs/remotes/%s/", remoteOrigin)
+		if branch, ok := strings.CutPrefix(headRef.Target().String(), prefix); ok && branch != "" {
+			LogInfof("Remote %s reports %s as its default branch", remoteOrigin, branch)
+			return branch, nil
+		}
 	}
 
-	LogInfof("Origin has been set to '%s', checking %d branches", remoteOrigin, len(remoteBranches))
+	// Every candidate is looked for on the remote before any locally
+	for _, local := range []bool{false, true} {
+		for _, candidate := range candidates {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "" {
+				continue
+			}
 
-	// Use concurrent processing for large branch sets
-	if len(remoteBranches) > 10 {
-		return findMergedBranchesConcurrent(ctx, masterCommits, remoteBranches)
+			name := plumbing.NewRemoteReferenceName(remoteOrigin, candidate)
+			if local {
+				name = plumbing.NewBranchReferenceName(candidate)
+			}
+			if _, refErr := repo.Reference(name, true); refErr == nil {
+				LogInfof("Using %s as the default branch, found at %s", candidate, name)
+				return candidate, nil
+			}
+		}
 	}
 
-	// Use sequential processing for smaller sets
-	return findMergedBranchesSequential(ctx, masterCommits, remoteBranches)
+	return "", fmt.Errorf(
+		"could not detect the default branch of %s (tried %s/HEAD and %s), please set --master",
+		remoteOrigin, remoteOrigin, strings.Join(candidates, ", "),
+	)
 }
 
-// getBranchHeads gets all branch heads.
-func getBranchHeads(repo *git.Repository) (map[string]plumbing.Hash, error) {
-	branchRefs, err := repo.Branches()
-	if err != nil {
-		return nil, fmt.Errorf("list branches failed: %w", err)
+// expandMasters turns master names and glob patterns into a sorted list of branch
+// names. Patterns are matched against the remote's branches, and against local
+// branches too when preferLocal is set; a pattern matching nothing is an error.
+func expandMasters(repo *git.Repository, remoteOrigin string, patterns []string, preferLocal bool) ([]string, error) {
+	var available []string
+	names := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" {
+			continue
+		}
+
+		if !strings.ContainsAny(pattern, "*?[") {
+			names[pattern] = true
+			continue
+		}
+
+		if available == nil {
+			var err error
+			available, err = branchNames(repo, remoteOrigin, preferLocal)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		matched := false
+		for _, name := range available {
+			ok, err := path.Match(pattern, name)
+			if err != nil {
+				return nil, fmt.Errorf("invalid master pattern %q: %w", pattern, err)
+			}
+			if ok {
+				names[name] = true
+				matched = true
+			}
+		}
+
+		if !matched {
+			return nil, fmt.Errorf("no branch matches master pattern %q", pattern)
+		}
 	}
 
-	branchHeads := make(map[string]plumbing.Hash)
+	if len(names) == 0 {
+		return nil, errors.New("no master branch given")
+	}
+
+	result := make([]string, 0, len(names))
+	for name := range names {
+		result = append(result, name)
+	}
+	sort.Strings(result)
+	return result, nil
+}
 
-	err = branchRefs.ForEach(func(reference *plumbing.Reference) error {
-		branchName := strings.TrimPrefix(reference.Name().String(), "refs/heads/")
-		branchHeads[branchName] = reference.Hash()
+// branchNames lists the short names of the remote's branches, plus local
+// branches when includeLocal is set.
+func branchNames(repo *git.Repository, remoteOrigin string, includeLocal bool) ([]string, error) {
+	var names []string
+
+	remoteBranches, err := RemoteBranches(repo.Storer)
+	if err != nil {
+		return nil, fmt.Errorf("list remote branches failed: %w", err)
+	}
+	err = remoteBranches.ForEach(func(ref *plumbing.Reference) error {
+		remote, short := ParseBranchName(strings.TrimPrefix(ref.Name().String(), "refs/remotes/"))
+		if remote == remoteOrigin {
+			names = append(names, short)
+		}
 		return nil
 	})
+	if err != nil {
+		return nil, fmt.Errorf("iterating remote branches failed: %w", err)
+	}
+
+	if !includeLocal {
+		return names, nil
+	}
 
+	localBranches, err := repo.Branches()
+	if err != nil {
+		return nil, fmt.Errorf("list branches failed: %w", err)
+	}
+	err = localBranches.ForEach(func(ref *plumbing.Reference) error {
+		names = append(names, ref.Name().Short())
+		return nil
+	})
 	if err != nil {
 		return nil, fmt.Errorf("iterating branches failed: %w", err)
 	}
 
-	return branchHeads, nil
+	return names, nil
+}
+
+// resolveMasterHash returns the commit the master branch points at. The
+// remote-tracking ref is used by default, as the local branch may be missing in
+// fresh clones or stale; preferLocal reverses the order. A warning is logged
+// when both refs exist but point at different commits.
+func resolveMasterHash(repo *git.Repository, remoteOrigin, masterBranchName string, preferLocal bool) (plumbing.Hash, error) {
+	remoteName := plumbing.NewRemoteReferenceName(remoteOrigin, masterBranchName)
+	localName := plumbing.NewBranchReferenceName(masterBranchName)
+
+	remoteRef, remoteErr := repo.Reference(remoteName, true)
+	localRef, localErr := repo.Reference(localName, true)
+
+	if remoteErr != nil && localErr != nil {
+		return plumbing.ZeroHash, fmt.Errorf("master branch %s not found", masterBranchName)
+	}
+
+	if remoteErr == nil && localErr == nil && remoteRef.Hash() != localRef.Hash() {
+		used := remoteName.Short()
+		if preferLocal {
+			used = localName.Short()
+		}
+		LogWarnf(
+			"%s (%s) and %s (%s) point at different commits, using %s",
+			localName.Short(), localRef.Hash().String()[:7],
+			remoteName.Short(), remoteRef.Hash().String()[:7],
+			used,
+		)
+	}
+
+	switch {
+	case preferLocal && localErr == nil, remoteErr != nil:
+		LogInfof("Using local branch %s as master", localName.Short())
+		return localRef.Hash(), nil
+	default:
+		LogInfof("Using remote-tracking branch %s as master", remoteName.Short())
+		return remoteRef.Hash(), nil
+	}
 }
 
 // getRemoteBranches gets remote branches with filtering.
 func getRemoteBranches(
 	repo *git.Repository,
 	remoteOrigin string,
-	masterBranchName string,
-	skipSet map[string]bool,
+	masterBranchNames []string,
+	filter branchFilter,
+	ages *branchAges,
 ) ([]BranchInfo, error) {
 	remoteBranches, err := RemoteBranches(repo.Storer)
 	if err != nil {
@@ -267,32 +677,38 @@ func getRemoteBranches(
 	}
 
 	var branches []BranchInfo
-	masterBranchRemote := fmt.Sprintf("%s/%s", remoteOrigin, masterBranchName)
+	masterBranchRemotes := make(map[string]bool, len(masterBranchNames))
+	for _, name := range masterBranchNames {
+		masterBranchRemotes[fmt.Sprintf("%s/%s", remoteOrigin, name)] = true
+	}
 
 	err = remoteBranches.ForEach(func(branch *plumbing.Reference) error {
 		remoteBranchName := strings.TrimPrefix(branch.Name().String(), "refs/remotes/")
 
-		// Skip master branch
-		if remoteBranchName == masterBranchRemote {
+		// Skip master branches
+		if masterBranchRemotes[remoteBranchName] {
 			return nil
 		}
 
 		remote, shortBranchName := ParseBranchName(remoteBranchName)
 
-		// Filter by origin and skip list
+		// Filter by origin and skip and only lists
 		if remote == remoteOrigin {
-			// Check if this branch should be skipped
-			if IsStringInSet(shortBranchName, skipSet) {
-				LogInfof("Branch '%s' matches skip branch string '[%s]'", remoteBranchName, shortBranchName)
+			if filter.excludes(remoteBranchName, shortBranchName) {
 				return nil
 			}
 
-			branches = append(branches, BranchInfo{
+			info := BranchInfo{
 				Name:   remoteBranchName,
 				Hash:   branch.Hash(),
 				Remote: remote,
 				Short:  shortBranchName,
-			})
+			}
+			if keep, err := ages.keep(info); err != nil || !keep {
+				return err
+			}
+
+			branches = append(branches, info)
 		}
 
 		return nil
@@ -305,219 +721,158 @@ func getRemoteBranches(
 	return branches, nil
 }
 
-// findMergedBranchesSequential processes branches sequentially with optimizations.
-func findMergedBranchesSequential(
-	ctx context.Context,
-	masterCommits object.CommitIter,
-	branches []BranchInfo,
-) ([]string, error) {
-	// Create hash lookup map
-	branchHashMap := make(map[plumbing.Hash][]BranchInfo, len(branches))
-	for _, branch := range branches {
-		branchHashMap[branch.Hash] = append(branchHashMap[branch.Hash], branch)
+// getLocalBranches gets local branches with filtering. The master branches are
+// never returned; checked out branches are, and are marked by markCheckedOut.
+func getLocalBranches(
+	repo *git.Repository,
+	masterBranchNames []string,
+	filter branchFilter,
+	ages *branchAges,
+) ([]BranchInfo, error) {
+	localBranches, err := repo.Branches()
+	if err != nil {
+		return nil, fmt.Errorf("list branches failed: %w", err)
 	}
 
-	var mergedBranches []string
-	foundBranches := make(map[string]bool)
-	commitCount := 0
+	masterSet := StringSliceToSet(masterBranchNames)
+	var branches []BranchInfo
 
-	err := masterCommits.ForEach(func(commit *object.Commit) error {
-		// Check context for cancellation
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-		}
+	err = localBranches.ForEach(func(branch *plumbing.Reference) error {
+		branchName := branch.Name().Short()
 
-		// Limit the number of commits to check
-		commitCount++
-		if commitCount > MaxCommitsToCheck {
-			LogInfof("Reached maximum commit limit (%d), stopping search", MaxCommitsToCheck)
-			return errors.New("max commits reached")
+		switch {
+		case masterSet[branchName]:
+			return nil
+		case filter.excludes(branchName, branchName):
+			return nil
 		}
 
-		// Early termination when all branches found
-		if len(foundBranches) == len(branches) {
-			return errors.New("all branches found")
+		info := BranchInfo{
+			Name:  branchName,
+			Hash:  branch.Hash(),
+			Short: branchName,
 		}
-
-		// Check if this commit hash matches any branch
-		if branchInfos, exists := branchHashMap[commit.Hash]; exists {
-			commitHash := commit.Hash.String()
-			for _, branchInfo := range branchInfos {
-				if !foundBranches[branchInfo.Name] {
-					LogInfof(
-						"Branch %s head (%s) was found in master, so has been merged!",
-						branchInfo.Name,
-						commitHash,
-					)
-					mergedBranches = append(mergedBranches, branchInfo.Name)
-					foundBranches[branchInfo.Name] = true
-				}
-			}
+		if keep, err := ages.keep(info); err != nil || !keep {
+			return err
 		}
 
+		branches = append(branches, info)
 		return nil
 	})
 
-	// Handle expected early termination errors
-	if err != nil && err.Error() != "all branches found" && err.Error() != "max commits reached" {
-		return nil, fmt.Errorf("looking for merged commits failed: %w", err)
+	if err != nil {
+		return nil, fmt.Errorf("iterating branches failed: %w", err)
+	}
+
+	return branches, nil
+}
+
+// currentBranch returns the short name of the branch HEAD points at, or an empty
+// string when HEAD is detached or cannot be read.
+func currentBranch(repo *git.Repository) string {
+	head, err := repo.Reference(plumbing.HEAD, false)
+	if err != nil || head.Type() != plumbing.SymbolicReference {
+		return ""
 	}
+	return head.Target().Short()
+}
 
-	sort.Strings(mergedBranches)
+// findMergedBranchesSequential checks branches one after another.
+func findMergedBranchesSequential(
+	ctx context.Context,
+	detector *mergeDetector,
+	branches []BranchInfo,
+) ([]MergedBranch, error) {
+	var mergedBranches []MergedBranch
+
+	for _, branch := range branches {
+		merged, ok, err := detector.detect(ctx, branch)
+		if err != nil {
+			return nil, fmt.Errorf("looking for merged commits failed: %w", err)
+		}
+		if ok {
+			mergedBranches = append(mergedBranches, merged)
+		}
+	}
+
+	sortMergedBranches(mergedBranches)
 	return mergedBranches, nil
 }
 
-// findMergedBranchesConcurrent processes branches using concurrent workers.
+// findMergedBranchesConcurrent checks branches using concurrent workers.
 func findMergedBranchesConcurrent(
 	ctx context.Context,
-	masterCommits object.CommitIter,
+	detector *mergeDetector,
 	branches []BranchInfo,
-) ([]string, error) {
-	// Create hash lookup map
-	branchHashMap := make(map[plumbing.Hash][]BranchInfo, len(branches))
-	for _, branch := range branches {
-		branchHashMap[branch.Hash] = append(branchHashMap[branch.Hash], branch)
-	}
+) ([]MergedBranch, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// Channel for commit batches
-	commitBatches := make(chan commitBatch, ConcurrentWorkers*2)
-	results := make(chan []string, ConcurrentWorkers)
+	jobs := make(chan BranchInfo)
+	results := make(chan MergedBranch, len(branches))
 
-	// Start worker goroutines
-	var wg sync.WaitGroup
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
 	numWorkers := minInt(ConcurrentWorkers, runtime.NumCPU())
 
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			mergedInWorker := processCommitBatches(ctx, commitBatches, branchHashMap, len(branches))
-			results <- mergedInWorker
-		}()
-	}
-
-	// Producer goroutine to batch commits
-	go func() {
-		defer close(commitBatches)
-
-		var batch []*object.Commit
-		commitCount := 0
-		batchStartIdx := 0
-
-		err := masterCommits.ForEach(func(commit *object.Commit) error {
-			// Check context for cancellation
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			default:
-			}
-
-			// Limit the number of commits to check
-			commitCount++
-			if commitCount > MaxCommitsToCheck {
-				return errors.New("max commits reached")
-			}
-
-			batch = append(batch, commit)
-
-			// Send batch when it's full
-			if len(batch) >= BatchSize {
-				select {
-				case commitBatches <- commitBatch{commits: batch, startIdx: batchStartIdx}:
-					batch = make([]*object.Commit, 0, BatchSize)
-					batchStartIdx = commitCount
-				case <-ctx.Done():
-					return ctx.Err()
+			for branch := range jobs {
+				merged, ok, err := detector.detect(ctx, branch)
+				if err != nil {
+					errOnce.Do(func() {
+						firstErr = err
+						cancel()
+					})
+					return
+				}
+				if ok {
+					results <- merged
 				}
 			}
-
-			return nil
-		})
-
-		// Send remaining commits
-		if len(batch) > 0 && err == nil {
-			select {
-			case commitBatches <- commitBatch{commits: batch, startIdx: batchStartIdx}:
-			case <-ctx.Done():
-			}
-		}
-	}()
-
-	// Wait for workers and collect results
-	go func() {
-		wg.Wait()
-		close(results)
-	}()
-
-	// Collect and merge results from all workers
-	var allMerged []string
-	seenBranches := make(map[string]bool)
-
-	for workerResults := range results {
-		for _, branch := range workerResults {
-			if !seenBranches[branch] {
-				allMerged = append(allMerged, branch)
-				seenBranches[branch] = true
-			}
-		}
-	}
-
-	sort.Strings(allMerged)
-
-	// Check if context was cancelled
-	select {
-	case <-ctx.Done():
-		return nil, ctx.Err()
-	default:
-		return allMerged, nil
+		}()
 	}
-}
 
-// processCommitBatches processes batches of commits in a worker goroutine.
-func processCommitBatches(
-	ctx context.Context,
-	batches <-chan commitBatch,
-	branchHashMap map[plumbing.Hash][]BranchInfo,
-	totalBranches int,
-) []string {
-	var mergedBranches []string
-	foundBranches := make(map[string]bool)
-
-	for batch := range batches {
-		// Check context for cancellation
+	// Feed branches to the workers until done or cancelled
+feed:
+	for _, branch := range branches {
 		select {
+		case jobs <- branch:
 		case <-ctx.Done():
-			return mergedBranches
-		default:
+			break feed
 		}
+	}
+	close(jobs)
 
-		// Early termination if all branches found
-		if len(foundBranches) >= totalBranches {
-			return mergedBranches
-		}
+	wg.Wait()
+	close(results)
 
-		// Process commits in this batch
-		for _, commit := range batch.commits {
-			if branchInfos, exists := branchHashMap[commit.Hash]; exists {
-				commitHash := commit.Hash.String()
-				for _, branchInfo := range branchInfos {
-					if !foundBranches[branchInfo.Name] {
-						LogInfof(
-							"Branch %s head (%s) was found in master, so has been merged!",
-							branchInfo.Name,
-							commitHash,
-						)
-						mergedBranches = append(mergedBranches, branchInfo.Name)
-						foundBranches[branchInfo.Name] = true
-					}
-				}
-			}
-		}
+	if firstErr != nil {
+		return nil, fmt.Errorf("looking for merged commits failed: %w", firstErr)
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	mergedBranches := make([]MergedBranch, 0, len(results))
+	for merged := range results {
+		mergedBranches = append(mergedBranches, merged)
 	}
 
-	return mergedBranches
+	sortMergedBranches(mergedBranches)
+	return mergedBranches, nil
+}
+
+// sortMergedBranches orders branches by name.
+func sortMergedBranches(branches []MergedBranch) {
+	sort.Slice(branches, func(i, j int) bool {
+		return branches[i].Name < branches[j].Name
+	})
 }
 
 // min returns the minimum of two integers.
@@ -527,3 +882,11 @@ func minInt(a, b int) int {
 	}
 	return b
 }
+
+// maxInt returns the maximum of two integers.
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
@@ -5,6 +5,7 @@ import (
 
 	"github.com/go-git/go-billy/v5/memfs"
 	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/storage/memory"
 	"github.com/stretchr/testify/assert"
@@ -44,7 +45,8 @@ func TestGetRemoteBranches(t *testing.T) {
 		name                string
 		masterBranchName    string
 		remoteBranches      map[string]string
-		skipBranches        map[string]bool
+		skipBranches        string
+		onlyBranches        string
 		expectedBranches    []string
 		expectedBranchCount int
 	}{
@@ -55,7 +57,6 @@ func TestGetRemoteBranches(t *testing.T) {
 				"refs/remotes/origin/main":           "1111111111111111111111111111111111111111",
 				"refs/remotes/origin/feature-branch": "2222222222222222222222222222222222222222",
 			},
-			skipBranches:        map[string]bool{},
 			expectedBranches:    []string{"origin/feature-branch"},
 			expectedBranchCount: 1,
 		},
@@ -65,7 +66,6 @@ func TestGetRemoteBranches(t *testing.T) {
 			remoteBranches: map[string]string{
 				"refs/remotes/origin/main": "1111111111111111111111111111111111111111",
 			},
-			skipBranches:        map[string]bool{},
 			expectedBranches:    []string{},
 			expectedBranchCount: 0,
 		},
@@ -77,7 +77,35 @@ func TestGetRemoteBranches(t *testing.T) {
 				"refs/remotes/origin/feature-branch": "2222222222222222222222222222222222222222",
 				"refs/remotes/origin/dont-delete":    "3333333333333333333333333333333333333333",
 			},
-			skipBranches:        map[string]bool{"dont-delete": true},
+			skipBranches:        "dont-delete",
+			expectedBranches:    []string{"origin/feature-branch"},
+			expectedBranchCount: 1,
+		},
+		{
+			name:             "skips branches matching patterns",
+			masterBranchName: "master",
+			remoteBranches: map[string]string{
+				"refs/remotes/origin/master":         "1111111111111111111111111111111111111111",
+				"refs/remotes/origin/feature-branch": "2222222222222222222222222222222222222222",
+				"refs/remotes/origin/release/1.0":    "3333333333333333333333333333333333333333",
+				"refs/remotes/origin/hotfix-123":     "4444444444444444444444444444444444444444",
+				"refs/remotes/origin/env/staging":    "5555555555555555555555555555555555555555",
+			},
+			skipBranches:        "release/*,glob:hotfix-*,re:^env/",
+			expectedBranches:    []string{"origin/feature-branch"},
+			expectedBranchCount: 1,
+		},
+		{
+			name:             "only keeps branches matching patterns",
+			masterBranchName: "master",
+			remoteBranches: map[string]string{
+				"refs/remotes/origin/master":         "1111111111111111111111111111111111111111",
+				"refs/remotes/origin/feature-branch": "2222222222222222222222222222222222222222",
+				"refs/remotes/origin/feature-skip":   "3333333333333333333333333333333333333333",
+				"refs/remotes/origin/hotfix-123":     "4444444444444444444444444444444444444444",
+			},
+			skipBranches:        "feature-skip",
+			onlyBranches:        "re:^feature-",
 			expectedBranches:    []string{"origin/feature-branch"},
 			expectedBranchCount: 1,
 		},
@@ -94,7 +122,10 @@ func TestGetRemoteBranches(t *testing.T) {
 				require.NoError(t, setErr)
 			}
 
-			branches, err := getRemoteBranches(repo, "origin", tc.masterBranchName, tc.skipBranches)
+			filter, err := newBranchFilter(tc.skipBranches, tc.onlyBranches)
+			require.NoError(t, err)
+
+			branches, err := getRemoteBranches(repo, "origin", []string{tc.masterBranchName}, filter, nil)
 			require.NoError(t, err)
 			assert.Len(t, branches, tc.expectedBranchCount)
 
@@ -107,3 +138,229 @@ func TestGetRemoteBranches(t *testing.T) {
 		})
 	}
 }
+
+func TestResolveMasterHash(t *testing.T) {
+	r := newTestRepo(t)
+	local := r.commit("initial", map[string]string{"README.md": "hello\n"})
+	remote := r.commit("upstream work", map[string]string{"README.md": "hello world\n"})
+	r.setRef("refs/heads/master", local)
+
+	// Only the local branch exists
+	hash, err := resolveMasterHash(r.repo, "origin", "master", false)
+	require.NoError(t, err)
+	assert.Equal(t, local, hash)
+
+	// The remote-tracking branch wins by default
+	r.setRef("refs/remotes/origin/master", remote)
+	hash, err = resolveMasterHash(r.repo, "origin", "master", false)
+	require.NoError(t, err)
+	assert.Equal(t, remote, hash)
+
+	hash, err = resolveMasterHash(r.repo, "origin", "master", true)
+	require.NoError(t, err)
+	assert.Equal(t, local, hash)
+
+	// Neither ref exists
+	hash, err = resolveMasterHash(r.repo, "origin", "main", true)
+	require.EqualError(t, err, "master branch main not found")
+	assert.Equal(t, plumbing.ZeroHash, hash)
+
+	// Only the remote-tracking branch exists
+	r.setRef("refs/remotes/origin/main", remote)
+	hash, err = resolveMasterHash(r.repo, "origin", "main", true)
+	require.NoError(t, err)
+	assert.Equal(t, remote, hash)
+}
+
+func TestDetectMasterBranch(t *testing.T) {
+	r := newTestRepo(t)
+	hash := r.commit("initial", map[string]string{"README.md": "hello\n"})
+	candidates := []string{"main", "master", "develop"}
+
+	// Falls back to the local branch when nothing exists on the remote
+	branch, err := DetectMasterBranch(r.repo, "origin", candidates)
+	require.NoError(t, err)
+	assert.Equal(t, "master", branch)
+
+	// Remote-tracking candidates are found
+	r.setRef("refs/remotes/origin/main", hash)
+	branch, err = DetectMasterBranch(r.repo, "origin", candidates)
+	require.NoError(t, err)
+	assert.Equal(t, "main", branch)
+
+	// The remote's HEAD wins over the candidates
+	r.setRef("refs/remotes/origin/trunk", hash)
+	require.NoError(t, r.repo.Storer.SetReference(plumbing.NewSymbolicReference(
+		plumbing.NewRemoteHEADReferenceName("origin"),
+		plumbing.NewRemoteReferenceName("origin", "trunk"),
+	)))
+	branch, err = DetectMasterBranch(r.repo, "origin", candidates)
+	require.NoError(t, err)
+	assert.Equal(t, "trunk", branch)
+
+	_, err = DetectMasterBranch(r.repo, "upstream", candidates)
+	require.EqualError(t, err, "Could not find the remote named upstream")
+}
+
+func TestDetectMasterBranch_NoCandidates(t *testing.T) {
+	r := newTestRepo(t)
+	r.commit("initial", map[string]string{"README.md": "hello\n"})
+
+	_, err := DetectMasterBranch(r.repo, "origin", []string{"main", "develop"})
+	require.EqualError(t, err,
+		"could not detect the default branch of origin (tried origin/HEAD and main, develop), please set --master")
+}
+
+func TestDetectMasterBranch_Fork(t *testing.T) {
+	// A remote without a HEAD, and a local main of the fork built on its master
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"})
+	r.checkout("feat", base)
+	feat := r.commit("feature", map[string]string{"feat.txt": "feat\n"})
+	r.checkout("upstream-master", feat)
+	master := r.commit("upstream work", map[string]string{"up.txt": "up\n"})
+	r.checkout("main", master)
+	r.commit("fork work", map[string]string{"fork.txt": "fork\n"})
+
+	_, err := r.repo.CreateRemote(&config.RemoteConfig{Name: "upstream", URLs: []string{"https://example.com/upstream.git"}})
+	require.NoError(t, err)
+	r.setRef("refs/remotes/upstream/master", master)
+	r.setRef("refs/remotes/upstream/feat", feat)
+	r.setRef("refs/remotes/upstream/develop", base)
+
+	candidates := []string{"main", "master", "develop"}
+	branch, err := DetectMasterBranch(r.repo, "upstream", candidates)
+	require.NoError(t, err)
+	assert.Equal(t, "master", branch)
+
+	// The other candidates are never offered for deletion
+	merged, err := GetMergedBranches(r.repo, MergedBranchesOptions{
+		Remote:    "upstream",
+		Masters:   []string{branch},
+		Protected: candidates,
+	})
+	require.NoError(t, err)
+	require.Len(t, merged, 1)
+	assert.Equal(t, "upstream/feat", merged[0].Name)
+}
+
+func TestGetMergedBranches_MultipleMasters(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"})
+
+	r.checkout("release/2.3", base)
+	hotfix := r.commit("hotfix", map[string]string{"fix.txt": "fix\n"})
+	r.commit("release notes", map[string]string{"NOTES.md": "2.3.1\n"})
+	release23, err := r.repo.Head()
+	require.NoError(t, err)
+
+	r.checkout("release/2.4", base)
+	release24 := r.commit("release 2.4", map[string]string{"NOTES.md": "2.4\n"})
+
+	r.checkout("master", plumbing.ZeroHash)
+	feature := r.commit("feature", map[string]string{"feature.txt": "feature\n"})
+
+	r.setRef("refs/remotes/origin/master", feature)
+	r.setRef("refs/remotes/origin/release/2.3", release23.Hash())
+	r.setRef("refs/remotes/origin/release/2.4", release24)
+	r.setRef("refs/remotes/origin/hotfix", hotfix)
+	r.setRef("refs/remotes/origin/feature", feature)
+	r.setRef("refs/remotes/origin/shared", base)
+
+	merged, err := GetMergedBranches(r.repo, MergedBranchesOptions{
+		Remote:  "origin",
+		Masters: []string{"master", "release/*"},
+	})
+	require.NoError(t, err)
+
+	masters := make(map[string][]string, len(merged))
+	for _, b := range merged {
+		masters[b.Name] = b.Masters
+	}
+	assert.Equal(t, map[string][]string{
+		"origin/feature": {"master"},
+		"origin/hotfix":  {"release/2.3"},
+		"origin/shared":  {"master", "release/2.3", "release/2.4"},
+	}, masters)
+
+	merged, err = GetMergedBranches(r.repo, MergedBranchesOptions{
+		Remote:     "origin",
+		Masters:    []string{"master", "release/*"},
+		RequireAll: true,
+	})
+	require.NoError(t, err)
+	require.Len(t, merged, 1)
+	assert.Equal(t, "origin/shared", merged[0].Name)
+}
+
+func TestExpandMasters(t *testing.T) {
+	r := newTestRepo(t)
+	hash := r.commit("initial", map[string]string{"README.md": "hello\n"})
+	r.setRef("refs/remotes/origin/release/1.0", hash)
+	r.setRef("refs/remotes/origin/release/2.0", hash)
+	r.setRef("refs/remotes/upstream/release/3.0", hash)
+	r.setRef("refs/heads/release/local", hash)
+
+	names, err := expandMasters(r.repo, "origin", []string{"release/*", "main", " main "}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"main", "release/1.0", "release/2.0"}, names)
+
+	names, err = expandMasters(r.repo, "origin", []string{"release/*"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"release/1.0", "release/2.0", "release/local"}, names)
+
+	_, err = expandMasters(r.repo, "origin", []string{"hotfix/*"}, false)
+	require.EqualError(t, err, `no branch matches master pattern "hotfix/*"`)
+}
+
+func TestGetMergedBranches_Local(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"})
+	r.setRef("refs/heads/merged", base)
+	r.setRef("refs/heads/skipped", base)
+
+	r.checkout("current", base)
+	r.checkout("unmerged", base)
+	r.commit("work in progress", map[string]string{"wip.txt": "wip\n"})
+
+	r.checkout("master", plumbing.ZeroHash)
+	r.commit("more work", map[string]string{"README.md": "hello world\n"})
+	r.checkout("current", plumbing.ZeroHash)
+
+	merged, err := GetMergedBranches(r.repo, MergedBranchesOptions{
+		Remote:  "origin",
+		Masters: []string{"master"},
+		Skip:    "skipped",
+		Local:   true,
+	})
+	require.NoError(t, err)
+	require.Len(t, merged, 2)
+
+	// The checked out branch is reported, but marked so that it is kept
+	assert.Equal(t, "current", merged[0].Name)
+	assert.NotEmpty(t, merged[0].CheckedOutIn)
+
+	assert.Equal(t, BranchInfo{Name: "merged", Hash: base, Short: "merged"}, merged[1].BranchInfo)
+	assert.Empty(t, merged[1].CheckedOutIn)
+}
+
+func TestDeleteLocalBranch(t *testing.T) {
+	r := newTestRepo(t)
+	hash := r.commit("initial", map[string]string{"README.md": "hello\n"})
+	r.setRef("refs/heads/feature", hash)
+	require.NoError(t, r.repo.CreateBranch(&config.Branch{
+		Name:   "feature",
+		Remote: "origin",
+		Merge:  plumbing.NewBranchReferenceName("feature"),
+	}))
+
+	require.NoError(t, DeleteLocalBranch(r.repo, "feature"))
+
+	_, err := r.repo.Reference(plumbing.NewBranchReferenceName("feature"), false)
+	require.ErrorIs(t, err, plumbing.ErrReferenceNotFound)
+	_, err = r.repo.Branch("feature")
+	require.ErrorIs(t, err, git.ErrBranchNotFound)
+
+	require.Error(t, DeleteLocalBranch(r.repo, "feature"))
+	require.EqualError(t, DeleteLocalBranch(r.repo, ""), "branch name cannot be empty")
+}
@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+)
+
+// GoneBranch is a local branch whose upstream branch has been deleted from the remote.
+type GoneBranch struct {
+	MergedBranch
+	// Upstream is the remote-tracking branch the local branch used to track,
+	// such as "origin/feature".
+	Upstream string
+	// Merged reports whether the branch has been merged into the masters. The
+	// embedded Strategy and Masters are only set when it has.
+	Merged bool
+	// Unpushed counts the commits of an unmerged branch that are in none of the
+	// masters, and so would be lost by deleting it.
+	Unpushed int
+}
+
+// GetGoneBranches finds local branches tracking a branch of opts.Remote that no
+// longer exists, as recorded by the branch.<name>.remote and branch.<name>.merge
+// entries of the repository config. Only the remote-tracking refs are looked at,
+// so the remote should be fetched with pruning first.
+//
+// Each branch is checked against the masters like GetMergedBranches does, and
+// the ones that are not merged report how many of their commits would be lost.
+// The masters are never returned, and branches checked out in a worktree are
+// marked with CheckedOutIn.
+func GetGoneBranches(repo *git.Repository, opts MergedBranchesOptions) ([]GoneBranch, error) {
+	filter, err := newBranchFilter(opts.Skip, opts.Only)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = checkRemoteExists(repo, opts.Remote); err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	masterNames, masterHashes, err := resolveMasters(repo, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	candidates, err := getGoneCandidates(repo, opts.Remote, masterNames, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(candidates) == 0 {
+		LogInfo("No branches found to check")
+		return []GoneBranch{}, nil
+	}
+
+	LogInfof("Checking %d local branches whose upstream on '%s' is gone", len(candidates), opts.Remote)
+
+	detector, err := newMergeDetector(repo, masterHashes, opts.Strategies, opts.RequireAll)
+	if err != nil {
+		return nil, err
+	}
+	defer detector.Close()
+
+	for i := range candidates {
+		branch := &candidates[i]
+
+		merged, ok, detectErr := detector.detect(ctx, branch.BranchInfo)
+		if detectErr != nil {
+			return nil, fmt.Errorf("looking for merged commits failed: %w", detectErr)
+		}
+		if ok {
+			branch.MergedBranch = merged
+			branch.Merged = true
+			continue
+		}
+
+		branch.Unpushed, err = detector.unmergedCommits(ctx, branch.BranchInfo)
+		if err != nil {
+			return nil, err
+		}
+		LogInfof("Branch %s has %d commits not found in any master", branch.Name, branch.Unpushed)
+	}
+
+	checkedOut, err := checkedOutBranches(repo)
+	if err != nil {
+		return nil, err
+	}
+	for i := range candidates {
+		candidates[i].CheckedOutIn = checkedOut[candidates[i].Short]
+	}
+
+	return candidates, nil
+}
+
+// getGoneCandidates lists the local branches configured to track a branch of
+// remoteOrigin whose remote-tracking ref no longer exists, sorted by name.
+func getGoneCandidates(
+	repo *git.Repository,
+	remoteOrigin string,
+	masterBranchNames []string,
+	filter branchFilter,
+) ([]GoneBranch, error) {
+	cfg, err := repo.Config()
+	if err != nil {
+		return nil, fmt.Errorf("reading repository config failed: %w", err)
+	}
+
+	remoteConfig, ok := cfg.Remotes[remoteOrigin]
+	if !ok {
+		return nil, fmt.Errorf("Could not find the remote named %s", remoteOrigin)
+	}
+
+	masterSet := StringSliceToSet(masterBranchNames)
+	var branches []GoneBranch
+
+	for name, branchConfig := range cfg.Branches {
+		if branchConfig.Remote != remoteOrigin || branchConfig.Merge == "" {
+			continue
+		}
+
+		ref, refErr := repo.Reference(plumbing.NewBranchReferenceName(name), false)
+		if refErr != nil {
+			LogInfof("Branch '%s' is configured but does not exist, ignoring it", name)
+			continue
+		}
+
+		tracking, found := trackingRefName(remoteConfig, branchConfig.Merge)
+		if !found {
+			LogInfof("Branch '%s' tracks %s, which %s does not fetch, ignoring it",
+				name, branchConfig.Merge, remoteOrigin)
+			continue
+		}
+
+		if _, refErr = repo.Reference(tracking, false); refErr == nil {
+			continue
+		}
+
+		switch {
+		case masterSet[name]:
+			continue
+		case filter.excludes(name, name):
+			continue
+		}
+
+		LogInfof("Branch '%s' tracks %s, which is gone", name, tracking.Short())
+		branches = append(branches, GoneBranch{
+			MergedBranch: MergedBranch{BranchInfo: BranchInfo{
+				Name:  name,
+				Hash:  ref.Hash(),
+				Short: name,
+			}},
+			Upstream: tracking.Short(),
+		})
+	}
+
+	sort.Slice(branches, func(i, j int) bool {
+		return branches[i].Name < branches[j].Name
+	})
+
+	return branches, nil
+}
+
+// trackingRefName maps the upstream branch merge, such as refs/heads/feature, to
+// the remote-tracking ref the remote's fetch refspecs store it under.
+func trackingRefName(remote *config.RemoteConfig, merge plumbing.ReferenceName) (plumbing.ReferenceName, bool) {
+	for _, refspec := range remote.Fetch {
+		if refspec.Match(merge) {
+			return refspec.Dst(merge), true
+		}
+	}
+	return "", false
+}
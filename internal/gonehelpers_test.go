@@ -0,0 +1,103 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// track configures the local branch name to track the branch of the same name on origin.
+func (r *testRepo) track(name string) {
+	r.t.Helper()
+
+	require.NoError(r.t, r.repo.CreateBranch(&config.Branch{
+		Name:   name,
+		Remote: "origin",
+		Merge:  plumbing.NewBranchReferenceName(name),
+	}))
+}
+
+func TestGetGoneBranches(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"})
+	r.setRef("refs/remotes/origin/master", base)
+
+	for _, name := range []string{"merged", "alive", "skipped", "untracked"} {
+		r.setRef("refs/heads/"+name, base)
+	}
+	r.setRef("refs/remotes/origin/alive", base)
+
+	r.checkout("wip", base)
+	r.commit("work in progress", map[string]string{"wip.txt": "wip\n"})
+	r.commit("more work", map[string]string{"wip.txt": "more wip\n"})
+
+	r.checkout("master", plumbing.ZeroHash)
+	for _, name := range []string{"master", "merged", "alive", "skipped", "wip"} {
+		r.track(name)
+	}
+
+	gone, err := GetGoneBranches(r.repo, MergedBranchesOptions{
+		Remote:  "origin",
+		Masters: []string{"master"},
+		Skip:    "skipped",
+	})
+	require.NoError(t, err)
+	require.Len(t, gone, 2)
+
+	assert.Equal(t, "merged", gone[0].Name)
+	assert.Equal(t, "origin/merged", gone[0].Upstream)
+	assert.True(t, gone[0].Merged)
+	assert.Equal(t, []string{"master"}, gone[0].Masters)
+
+	assert.Equal(t, "wip", gone[1].Name)
+	assert.Equal(t, "origin/wip", gone[1].Upstream)
+	assert.False(t, gone[1].Merged)
+	assert.Equal(t, 2, gone[1].Unpushed)
+}
+
+func TestTrackingRefName(t *testing.T) {
+	remote := &config.RemoteConfig{
+		Name:  "upstream",
+		Fetch: []config.RefSpec{"+refs/heads/*:refs/remotes/mirror/*"},
+	}
+
+	tracking, ok := trackingRefName(remote, "refs/heads/feature/x")
+	require.True(t, ok)
+	assert.Equal(t, plumbing.ReferenceName("refs/remotes/mirror/feature/x"), tracking)
+
+	_, ok = trackingRefName(remote, "refs/tags/v1")
+	assert.False(t, ok)
+}
+
+func TestGetGoneBranches_EqualTimes(t *testing.T) {
+	// Every commit is made in the same second, so only reachability tells which
+	// commits the branch shares with master
+	r := newTestRepo(t)
+	r.step = 0
+	r.commit("initial", map[string]string{"README.md": "hello\n"})
+	r.commit("second", map[string]string{"README.md": "hello again\n"})
+	base := r.commit("third", map[string]string{"README.md": "hello once more\n"})
+
+	r.checkout("wip", base)
+	r.commit("work in progress", map[string]string{"wip.txt": "wip\n"})
+	r.commit("more work", map[string]string{"wip.txt": "more wip\n"})
+
+	r.checkout("master", plumbing.ZeroHash)
+	r.commit("master work", map[string]string{"a.txt": "a\n"})
+	r.commit("more master work", map[string]string{"a.txt": "aa\n"})
+	masterHead := r.commit("even more master work", map[string]string{"a.txt": "aaa\n"})
+	r.setRef("refs/remotes/origin/master", masterHead)
+	r.track("master")
+	r.track("wip")
+
+	gone, err := GetGoneBranches(r.repo, MergedBranchesOptions{Remote: "origin", Masters: []string{"master"}})
+	require.NoError(t, err)
+	require.Len(t, gone, 1)
+
+	assert.Equal(t, "wip", gone[0].Name)
+	assert.False(t, gone[0].Merged)
+	assert.Equal(t, 2, gone[0].Unpushed)
+}
@@ -0,0 +1,345 @@
+package internal
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// JournalFile is where deletions are recorded, relative to the repository's
+// common git directory, so that all worktrees share one journal.
+const JournalFile = "gitsweeper/journal.jsonl"
+
+// runIDFormat formats the time a run started as the start of its identifier,
+// which newRunID follows with a random suffix so that runs started within the
+// same second differ.
+const runIDFormat = "20060102-150405"
+
+// JournalEntry records one deleted branch, as a line of JSON in the journal.
+type JournalEntry struct {
+	// Run identifies the gitsweeper invocation that deleted the branch.
+	Run  string    `json:"run"`
+	Time time.Time `json:"time"`
+	// Branch is the short name of the branch, such as "feature".
+	Branch string `json:"branch"`
+	// Remote is the remote the branch was deleted from, empty for local branches.
+	Remote string `json:"remote,omitempty"`
+	Hash   string `json:"hash"`
+	User   string `json:"user"`
+	// URL is the first URL of the remote at the time of deletion.
+	URL string `json:"url,omitempty"`
+}
+
+// Name returns the branch name as gitsweeper prints it, such as "origin/feature".
+func (e JournalEntry) Name() string {
+	if e.Remote == "" {
+		return e.Branch
+	}
+	return e.Remote + "/" + e.Branch
+}
+
+// ShortHash returns the abbreviated hash of the branch head, or the hash as it
+// is when it is too short, as in a journal edited by hand.
+func (e JournalEntry) ShortHash() string {
+	return shortHash(e.Hash)
+}
+
+// Journal appends the branches deleted by one run to the repository's journal.
+type Journal struct {
+	repo *git.Repository
+	file *os.File
+	run  string
+	user string
+}
+
+// OpenJournal starts a new run in the journal of repo, creating the journal if
+// needed, so that a journal that cannot be written fails before anything is
+// deleted. The journal must be closed once the run is over.
+func OpenJournal(repo *git.Repository) (*Journal, error) {
+	path, err := journalPath(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("creating journal directory failed: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("opening journal failed: %w", err)
+	}
+
+	return &Journal{
+		repo: repo,
+		file: f,
+		run:  newRunID(time.Now()),
+		user: journalUser(repo),
+	}, nil
+}
+
+// newRunID returns the identifier of a run started at t, such as
+// "20240102-100000-3f9a1c".
+func newRunID(t time.Time) string {
+	suffix := make([]byte, 3)
+	_, _ = rand.Read(suffix)
+	return t.UTC().Format(runIDFormat) + "-" + hex.EncodeToString(suffix)
+}
+
+// Run returns the identifier of the run, to be given to `gitsweeper restore`.
+func (j *Journal) Run() string {
+	return j.run
+}
+
+// Record appends the deletion of branch to the journal.
+func (j *Journal) Record(branch BranchInfo) error {
+	entry := JournalEntry{
+		Run:    j.run,
+		Time:   time.Now().UTC(),
+		Branch: branch.Short,
+		Remote: branch.Remote,
+		Hash:   branch.Hash.String(),
+		User:   j.user,
+	}
+
+	if branch.Remote != "" {
+		if remote, err := j.repo.Remote(branch.Remote); err == nil && len(remote.Config().URLs) > 0 {
+			entry.URL = remote.Config().URLs[0]
+		}
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding journal entry failed: %w", err)
+	}
+
+	if _, err = j.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("writing journal failed: %w", err)
+	}
+	return nil
+}
+
+// Close closes the journal, reporting any write that failed late.
+func (j *Journal) Close() error {
+	if err := j.file.Close(); err != nil {
+		return fmt.Errorf("closing journal failed: %w", err)
+	}
+	return nil
+}
+
+// ReadJournal returns every entry of the repository's journal, oldest first.
+// A repository without a journal has no entries.
+func ReadJournal(repo *git.Repository) ([]JournalEntry, error) {
+	path, err := journalPath(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return []JournalEntry{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("opening journal failed: %w", err)
+	}
+	defer f.Close()
+
+	entries := []JournalEntry{}
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var entry JournalEntry
+		if err = json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("reading journal line %d failed: %w", line, err)
+		}
+		entries = append(entries, entry)
+	}
+
+	if err = scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading journal failed: %w", err)
+	}
+
+	return entries, nil
+}
+
+// SelectJournalRun returns the entries of the given run, or of the latest run
+// when run is empty, restricted to the named branches when any are given. Names
+// may be short ("feature") or include the remote ("origin/feature").
+func SelectJournalRun(entries []JournalEntry, run string, names []string) (string, []JournalEntry, error) {
+	if len(entries) == 0 {
+		return "", nil, errors.New("the journal is empty, no branches have been deleted yet")
+	}
+
+	if run == "" {
+		run = entries[len(entries)-1].Run
+	}
+
+	wanted := StringSliceToSet(names)
+	found := make(map[string]bool, len(names))
+	var selected []JournalEntry
+
+	for _, entry := range entries {
+		if entry.Run != run {
+			continue
+		}
+		if len(names) > 0 && !wanted[entry.Branch] && !wanted[entry.Name()] {
+			continue
+		}
+
+		found[entry.Branch] = true
+		found[entry.Name()] = true
+		selected = append(selected, entry)
+	}
+
+	if len(selected) == 0 && len(names) == 0 {
+		return "", nil, fmt.Errorf("no run %s in the journal", run)
+	}
+
+	for _, name := range names {
+		if !found[name] {
+			return "", nil, fmt.Errorf("branch %s was not deleted in run %s", name, run)
+		}
+	}
+
+	return run, selected, nil
+}
+
+// RestoreBranches recreates the branches of the journal entries at their
+// recorded hashes and returns one error per entry, in order. Remote branches
+// are pushed back with `git push`, guarded by a lease so that a branch that has
+// been recreated since is never overwritten, although one already back at its
+// recorded hash counts as restored; local branches are recreated directly. The
+// recorded commits must still be present in the repository.
+func RestoreBranches(ctx context.Context, repo *git.Repository, entries []JournalEntry) []error {
+	errs := make([]error, len(entries))
+	byRemote := make(map[string][]int)
+	var remotes []string
+
+	for i, entry := range entries {
+		hash := plumbing.NewHash(entry.Hash)
+		if _, err := repo.CommitObject(hash); err != nil {
+			errs[i] = fmt.Errorf("commit %s of branch %s is no longer present locally: %w", entry.Hash, entry.Name(), err)
+			continue
+		}
+
+		if entry.Remote == "" {
+			errs[i] = restoreLocalBranch(repo, entry.Branch, hash)
+			continue
+		}
+
+		if _, ok := byRemote[entry.Remote]; !ok {
+			remotes = append(remotes, entry.Remote)
+		}
+		byRemote[entry.Remote] = append(byRemote[entry.Remote], i)
+	}
+
+	for _, remote := range remotes {
+		pending := byRemote[remote]
+		for start := 0; start < len(pending); start += DeleteBatchSize {
+			if err := ctx.Err(); err != nil {
+				for _, i := range pending[start:] {
+					errs[i] = fmt.Errorf("not restoring branch %s: %w", entries[i].Name(), err)
+				}
+				break
+			}
+
+			batch := pending[start:minInt(start+DeleteBatchSize, len(pending))]
+			restoreBatch(repo, remote, entries, batch, errs)
+		}
+	}
+
+	return errs
+}
+
+// restoreBatch pushes the entries at the batch's indexes back to remote, storing
+// the outcome of each at the same index of errs.
+func restoreBatch(repo *git.Repository, remote string, entries []JournalEntry, batch []int, errs []error) {
+	var refspecs, leases []string
+	for _, i := range batch {
+		refName := plumbing.NewBranchReferenceName(entries[i].Branch)
+		// An empty expected value only lets the push create the branch
+		leases = append(leases, refName.String()+":")
+		refspecs = append(refspecs, fmt.Sprintf("%s:%s", entries[i].Hash, refName))
+	}
+
+	LogInfof("Restoring %d branches to remote %s", len(batch), remote)
+	statuses, output, err := pushRefs(repo, remote, refspecs, leases)
+
+	for _, i := range batch {
+		status, ok := statuses[plumbing.NewBranchReferenceName(entries[i].Branch)]
+		switch {
+		case ok && (status.Flag == pushCreated || status.Flag == pushUpToDate):
+			errs[i] = nil
+		case ok && strings.Contains(status.Summary, "(stale info)"):
+			errs[i] = fmt.Errorf("branch %s already exists on remote %s", entries[i].Branch, remote)
+		case ok:
+			errs[i] = fmt.Errorf("failed to restore branch %s on remote %s: %s", entries[i].Branch, remote, status.Summary)
+		case err == nil:
+			errs[i] = fmt.Errorf("failed to restore branch %s on remote %s: git push reported no status\nOutput: %s",
+				entries[i].Branch, remote, output)
+		default:
+			errs[i] = fmt.Errorf("failed to restore branch %s on remote %s: %w\nOutput: %s",
+				entries[i].Branch, remote, err, output)
+		}
+	}
+}
+
+// restoreLocalBranch creates the local branch at hash, unless it exists again.
+func restoreLocalBranch(repo *git.Repository, branchShortName string, hash plumbing.Hash) error {
+	refName := plumbing.NewBranchReferenceName(branchShortName)
+	if _, err := repo.Reference(refName, false); err == nil {
+		return fmt.Errorf("branch %s already exists", branchShortName)
+	}
+
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(refName, hash)); err != nil {
+		return fmt.Errorf("failed to restore branch %s: %w", branchShortName, err)
+	}
+	return nil
+}
+
+// journalPath returns the path of the journal of repo, which must be on disk.
+func journalPath(repo *git.Repository) (string, error) {
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return "", errors.New("the deletion journal needs a repository on disk")
+	}
+
+	commonDir, err := gitCommonDir(storage.Filesystem().Root())
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(commonDir, filepath.FromSlash(JournalFile)), nil
+}
+
+// journalUser identifies who is running gitsweeper: the git identity when one is
+// configured, otherwise the operating system user.
+func journalUser(repo *git.Repository) string {
+	if cfg, err := repo.ConfigScoped(config.GlobalScope); err == nil && cfg.User.Name != "" {
+		if cfg.User.Email == "" {
+			return cfg.User.Name
+		}
+		return fmt.Sprintf("%s <%s>", cfg.User.Name, cfg.User.Email)
+	}
+
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	return os.Getenv("USER")
+}
@@ -0,0 +1,159 @@
+package internal
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestJournal(t *testing.T) {
+	d := newDiskRepo(t)
+	hash := d.commit("initial")
+
+	entries, err := ReadJournal(d.repo)
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+
+	journal, err := OpenJournal(d.repo)
+	require.NoError(t, err)
+	require.NoError(t, journal.Record(BranchInfo{Name: "origin/feature", Hash: hash, Remote: "origin", Short: "feature"}))
+	require.NoError(t, journal.Record(BranchInfo{Name: "old", Hash: hash, Short: "old"}))
+	require.NoError(t, journal.Close())
+
+	entries, err = ReadJournal(d.repo)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+
+	assert.Equal(t, journal.Run(), entries[0].Run)
+	assert.Equal(t, "feature", entries[0].Branch)
+	assert.Equal(t, "origin", entries[0].Remote)
+	assert.Equal(t, "origin/feature", entries[0].Name())
+	assert.Equal(t, hash.String(), entries[0].Hash)
+	assert.Contains(t, entries[0].URL, "origin.git")
+	assert.NotEmpty(t, entries[0].User)
+	assert.False(t, entries[0].Time.IsZero())
+
+	assert.Equal(t, "old", entries[1].Name())
+	assert.Empty(t, entries[1].URL)
+	assert.Equal(t, hash.String()[:7], entries[1].ShortHash())
+
+	// A hash cut short, as by a hand edit, is shown as it is
+	assert.Equal(t, "1a2b", JournalEntry{Hash: "1a2b"}.ShortHash())
+	assert.Empty(t, JournalEntry{}.ShortHash())
+}
+
+func TestOpenJournal_RunsDiffer(t *testing.T) {
+	d := newDiskRepo(t)
+	d.commit("initial")
+
+	// Runs started within the same second still get their own identifier
+	first, err := OpenJournal(d.repo)
+	require.NoError(t, err)
+	require.NoError(t, first.Close())
+	second, err := OpenJournal(d.repo)
+	require.NoError(t, err)
+	require.NoError(t, second.Close())
+
+	assert.Regexp(t, `^\d{8}-\d{6}-[0-9a-f]{6}$`, first.Run())
+	assert.NotEqual(t, first.Run(), second.Run())
+}
+
+func TestOpenJournal_Unwritable(t *testing.T) {
+	d := newDiskRepo(t)
+	d.commit("initial")
+
+	// The journal directory is taken by a file
+	path, err := journalPath(d.repo)
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Dir(path), nil, 0o644))
+
+	_, err = OpenJournal(d.repo)
+	require.ErrorContains(t, err, "creating journal directory failed")
+}
+
+func TestSelectJournalRun(t *testing.T) {
+	entries := []JournalEntry{
+		{Run: "20240101-100000", Branch: "a", Remote: "origin"},
+		{Run: "20240102-100000", Branch: "b", Remote: "origin"},
+		{Run: "20240102-100000", Branch: "c", Remote: "origin"},
+		{Run: "20240102-100000", Branch: "d"},
+	}
+
+	run, selected, err := SelectJournalRun(entries, "", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "20240102-100000", run)
+	assert.Len(t, selected, 3)
+
+	_, selected, err = SelectJournalRun(entries, "", []string{"origin/b", "d"})
+	require.NoError(t, err)
+	require.Len(t, selected, 2)
+	assert.Equal(t, "b", selected[0].Branch)
+	assert.Equal(t, "d", selected[1].Branch)
+
+	_, selected, err = SelectJournalRun(entries, "20240101-100000", nil)
+	require.NoError(t, err)
+	require.Len(t, selected, 1)
+	assert.Equal(t, "a", selected[0].Branch)
+
+	_, _, err = SelectJournalRun(entries, "", []string{"a"})
+	require.EqualError(t, err, "branch a was not deleted in run 20240102-100000")
+
+	_, _, err = SelectJournalRun(entries, "20230101-100000", nil)
+	require.EqualError(t, err, "no run 20230101-100000 in the journal")
+
+	_, _, err = SelectJournalRun(nil, "", nil)
+	require.Error(t, err)
+}
+
+func TestRestoreBranches(t *testing.T) {
+	d := newDiskRepo(t)
+	hash := d.commit("initial")
+	d.push(hash, "master", "feature", "recreated")
+
+	errs := DeleteBranches(context.Background(), d.repo, "origin", []BranchInfo{
+		{Short: "feature", Hash: hash},
+		{Short: "recreated", Hash: hash},
+	}, DeleteOptions{})
+	require.NoError(t, errs[0])
+	require.NoError(t, errs[1])
+
+	// Someone pushes a new branch under the same name in the meantime
+	other := d.commit("other work")
+	d.push(other, "recreated")
+
+	entries := []JournalEntry{
+		{Branch: "feature", Remote: "origin", Hash: hash.String()},
+		{Branch: "recreated", Remote: "origin", Hash: hash.String()},
+		{Branch: "old", Hash: hash.String()},
+		{Branch: "lost", Remote: "origin", Hash: "0123456789012345678901234567890123456789"},
+	}
+
+	errs = RestoreBranches(context.Background(), d.repo, entries)
+	require.Len(t, errs, 4)
+	require.NoError(t, errs[0])
+	require.EqualError(t, errs[1], "branch recreated already exists on remote origin")
+	require.NoError(t, errs[2])
+	require.Error(t, errs[3])
+	assert.Contains(t, errs[3].Error(), "is no longer present locally")
+
+	ref, err := d.origin.Reference(plumbing.NewBranchReferenceName("feature"), false)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+
+	ref, err = d.origin.Reference(plumbing.NewBranchReferenceName("recreated"), false)
+	require.NoError(t, err)
+	assert.Equal(t, other, ref.Hash())
+
+	ref, err = d.repo.Reference(plumbing.NewBranchReferenceName("old"), false)
+	require.NoError(t, err)
+	assert.Equal(t, hash, ref.Hash())
+
+	// Restoring again finds the remote branch already at its recorded commit
+	errs = RestoreBranches(context.Background(), d.repo, entries[:1])
+	require.NoError(t, errs[0])
+}
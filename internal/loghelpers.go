@@ -32,6 +32,10 @@ func LogInfof(format string, args ...interface{}) {
 	}
 }
 
+func LogWarnf(format string, args ...interface{}) {
+	logger.Printf("[WARN] "+format, args...)
+}
+
 func LogFatal(msg string) {
 	logger.Println("[FATAL]", msg)
 	os.Exit(1)
@@ -0,0 +1,255 @@
+package internal
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"gopkg.in/yaml.v3"
+)
+
+// OutputFormat is how preview and cleanup report the branches they handle.
+type OutputFormat string
+
+const (
+	// OutputText is prose for people to read, whose wording may change.
+	OutputText OutputFormat = "text"
+	// OutputJSON is a single JSON array of branch records.
+	OutputJSON OutputFormat = "json"
+	// OutputYAML is a YAML sequence of branch records.
+	OutputYAML OutputFormat = "yaml"
+	// OutputCSV is a header line followed by one line per branch record.
+	OutputCSV OutputFormat = "csv"
+	// OutputNDJSON is one JSON branch record per line.
+	OutputNDJSON OutputFormat = "ndjson"
+)
+
+// Branch record results, telling what became of a branch.
+const (
+	// ResultMerged is a merged branch listed by preview, left as it is.
+	ResultMerged = "merged"
+	// ResultKept is a merged branch kept as it is checked out in a worktree.
+	ResultKept = "kept"
+	// ResultDeleted is a merged branch that was deleted.
+	ResultDeleted = "deleted"
+	// ResultArchived is a merged branch that was archived, then deleted.
+	ResultArchived = "archived"
+	// ResultSkipped is a merged branch left as it moved since it was listed.
+	ResultSkipped = "skipped"
+	// ResultFailed is a merged branch that could not be deleted.
+	ResultFailed = "failed"
+)
+
+// recordFields are the CSV columns, in the order of the BranchRecord fields.
+var recordFields = []string{"name", "remote", "short", "hash", "reason", "masters", "result", "error"}
+
+// BranchRecord is what structured output reports about one branch. Its fields
+// are always present, empty when they do not apply, so that the schema does not
+// depend on the outcome.
+type BranchRecord struct {
+	Name string `json:"name" yaml:"name"`
+	// Remote is empty for local branches.
+	Remote string `json:"remote" yaml:"remote"`
+	Short  string `json:"short" yaml:"short"`
+	Hash   string `json:"hash" yaml:"hash"`
+	// Reason is the detection strategy that found the branch merged.
+	Reason DetectionStrategy `json:"reason" yaml:"reason"`
+	// Masters are the masters the branch was found merged into.
+	Masters []string `json:"masters" yaml:"masters"`
+	Result  string   `json:"result" yaml:"result"`
+	Error   string   `json:"error" yaml:"error"`
+}
+
+// ParseOutputFormat parses the value of --output.
+func ParseOutputFormat(s string) (OutputFormat, error) {
+	format := OutputFormat(strings.ToLower(strings.TrimSpace(s)))
+	switch format {
+	case OutputText, OutputJSON, OutputYAML, OutputCSV, OutputNDJSON:
+		return format, nil
+	case "":
+		return OutputText, nil
+	}
+	return "", fmt.Errorf("unknown output format %q, use text, json, yaml, csv or ndjson", s)
+}
+
+// NewBranchRecord returns the record of a merged branch with the given result,
+// and the error that caused it, if any.
+func NewBranchRecord(branch MergedBranch, result string, err error) BranchRecord {
+	record := BranchRecord{
+		Name:    branch.Name,
+		Remote:  branch.Remote,
+		Short:   branch.Short,
+		Hash:    branch.Hash.String(),
+		Reason:  branch.Strategy,
+		Masters: branch.Masters,
+		Result:  result,
+	}
+
+	if record.Masters == nil {
+		record.Masters = []string{}
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	if result == ResultKept && err == nil {
+		record.Error = "checked out in " + branch.CheckedOutIn
+	}
+
+	return record
+}
+
+// WriteRecords writes the records to w in the given structured format. An empty
+// list is still written as a valid document of that format.
+func WriteRecords(w io.Writer, format OutputFormat, records []BranchRecord) error {
+	if records == nil {
+		records = []BranchRecord{}
+	}
+
+	switch format {
+	case OutputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	case OutputNDJSON:
+		encoder := json.NewEncoder(w)
+		for _, record := range records {
+			if err := encoder.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	case OutputYAML:
+		encoder := yaml.NewEncoder(w)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(records); err != nil {
+			return err
+		}
+		return encoder.Close()
+	case OutputCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write(recordFields); err != nil {
+			return err
+		}
+		for _, record := range records {
+			row := []string{
+				record.Name, record.Remote, record.Short, record.Hash, string(record.Reason),
+				strings.Join(record.Masters, ";"), record.Result, record.Error,
+			}
+			if err := writer.Write(row); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	}
+	return fmt.Errorf("output format %s has no records", format)
+}
+
+// BranchDetails is what --format templates are executed with for each branch:
+// the branch, the metadata of its head commit and what became of it.
+type BranchDetails struct {
+	MergedBranch
+	// Author is the name of the author of the head commit.
+	Author      string
+	AuthorEmail string
+	// Date is when the head commit was committed.
+	Date time.Time
+	// Subject is the first line of the message of the head commit.
+	Subject string
+	// Result and Error are as in BranchRecord.
+	Result string
+	Error  string
+}
+
+// GetBranchDetails looks up the head commit of the branch for its details.
+func GetBranchDetails(repo *git.Repository, branch MergedBranch) (BranchDetails, error) {
+	commit, err := repo.CommitObject(branch.Hash)
+	if err != nil {
+		return BranchDetails{}, fmt.Errorf("could not read head commit %s of branch %s: %w", branch.Hash, branch.Name, err)
+	}
+
+	subject, _, _ := strings.Cut(strings.TrimSpace(commit.Message), "\n")
+	return BranchDetails{
+		MergedBranch: branch,
+		Author:       commit.Author.Name,
+		AuthorEmail:  commit.Author.Email,
+		Date:         commit.Committer.When,
+		Subject:      strings.TrimSpace(subject),
+	}, nil
+}
+
+// Age returns how long ago the head commit was committed, such as "3 days ago".
+func (d BranchDetails) Age() string {
+	return RelativeAge(d.Date, time.Now())
+}
+
+// RelativeAge describes how long before now t was, rounded down to the largest
+// unit that fits, as in "3 weeks ago".
+func RelativeAge(t, now time.Time) string {
+	const day = 24 * time.Hour
+
+	age := now.Sub(t)
+	switch {
+	case age < 0:
+		return "in the future"
+	case age < time.Minute:
+		return "just now"
+	case age < time.Hour:
+		return agoString(int(age/time.Minute), "minute")
+	case age < day:
+		return agoString(int(age/time.Hour), "hour")
+	case age < 14*day:
+		return agoString(int(age/day), "day")
+	case age < 60*day:
+		return agoString(int(age/(7*day)), "week")
+	case age < 365*day:
+		return agoString(int(age/(30*day)), "month")
+	}
+	return agoString(int(age/(365*day)), "year")
+}
+
+// agoString returns "1 day ago" or "n days ago".
+func agoString(n int, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s ago", unit)
+	}
+	return fmt.Sprintf("%d %ss ago", n, unit)
+}
+
+// ParseFormat parses the value of --format, a text/template executed with the
+// BranchDetails of each branch. Fields BranchDetails does not have are reported
+// here, before any branch is deleted, rather than once the output is written.
+func ParseFormat(format string) (*template.Template, error) {
+	tmpl, err := template.New("format").Parse(format)
+	if err != nil {
+		return nil, err
+	}
+
+	// Other errors may depend on the values, so only show for actual branches
+	err = tmpl.Execute(io.Discard, BranchDetails{})
+	if err != nil && strings.Contains(err.Error(), "can't evaluate field") {
+		return nil, err
+	}
+	return tmpl, nil
+}
+
+// WriteFormatted executes the template for each branch, writing each result to
+// w on a line of its own.
+func WriteFormatted(w io.Writer, tmpl *template.Template, branches []BranchDetails) error {
+	for _, branch := range branches {
+		var line strings.Builder
+		if err := tmpl.Execute(&line, branch); err != nil {
+			return fmt.Errorf("formatting branch %s failed: %w", branch.Name, err)
+		}
+
+		if _, err := fmt.Fprintln(w, strings.TrimSuffix(line.String(), "\n")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
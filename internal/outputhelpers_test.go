@@ -0,0 +1,166 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/yaml.v3"
+)
+
+func TestParseOutputFormat(t *testing.T) {
+	for input, expected := range map[string]OutputFormat{
+		"":       OutputText,
+		"text":   OutputText,
+		"JSON":   OutputJSON,
+		" yaml ": OutputYAML,
+		"csv":    OutputCSV,
+		"ndjson": OutputNDJSON,
+	} {
+		format, err := ParseOutputFormat(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, expected, format, input)
+	}
+
+	_, err := ParseOutputFormat("xml")
+	require.EqualError(t, err, `unknown output format "xml", use text, json, yaml, csv or ndjson`)
+}
+
+func testRecords() []BranchRecord {
+	hash := plumbing.NewHash("605999f514798915490a1887aa255ea56393de07")
+	merged := MergedBranch{
+		BranchInfo: BranchInfo{Name: "origin/feature", Hash: hash, Remote: "origin", Short: "feature"},
+		Strategy:   StrategySquash,
+		Masters:    []string{"master"},
+	}
+	kept := MergedBranch{
+		BranchInfo:   BranchInfo{Name: "current", Hash: hash, Short: "current"},
+		Strategy:     StrategyHash,
+		CheckedOutIn: "/src/repo",
+	}
+
+	return []BranchRecord{
+		NewBranchRecord(merged, ResultFailed, errors.New("remote rejected, with \"quotes\"")),
+		NewBranchRecord(kept, ResultKept, nil),
+	}
+}
+
+func TestNewBranchRecord(t *testing.T) {
+	records := testRecords()
+
+	assert.Equal(t, BranchRecord{
+		Name:    "origin/feature",
+		Remote:  "origin",
+		Short:   "feature",
+		Hash:    "605999f514798915490a1887aa255ea56393de07",
+		Reason:  StrategySquash,
+		Masters: []string{"master"},
+		Result:  ResultFailed,
+		Error:   "remote rejected, with \"quotes\"",
+	}, records[0])
+
+	assert.Empty(t, records[1].Remote)
+	assert.Equal(t, []string{}, records[1].Masters)
+	assert.Equal(t, "checked out in /src/repo", records[1].Error)
+}
+
+func TestWriteRecords(t *testing.T) {
+	records := testRecords()
+
+	var out bytes.Buffer
+	require.NoError(t, WriteRecords(&out, OutputJSON, records))
+	var decoded []BranchRecord
+	require.NoError(t, json.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, records, decoded)
+
+	out.Reset()
+	require.NoError(t, WriteRecords(&out, OutputYAML, records))
+	decoded = nil
+	require.NoError(t, yaml.Unmarshal(out.Bytes(), &decoded))
+	assert.Equal(t, records, decoded)
+
+	out.Reset()
+	require.NoError(t, WriteRecords(&out, OutputNDJSON, records))
+	lines := bytes.Split(bytes.TrimSpace(out.Bytes()), []byte("\n"))
+	require.Len(t, lines, 2)
+	assert.JSONEq(t, `{"name": "current", "remote": "", "short": "current",
+		"hash": "605999f514798915490a1887aa255ea56393de07", "reason": "hash", "masters": [],
+		"result": "kept", "error": "checked out in /src/repo"}`, string(lines[1]))
+
+	out.Reset()
+	require.NoError(t, WriteRecords(&out, OutputCSV, records))
+	assert.Equal(t, "name,remote,short,hash,reason,masters,result,error\n"+
+		"origin/feature,origin,feature,605999f514798915490a1887aa255ea56393de07,squash,master,failed,"+
+		"\"remote rejected, with \"\"quotes\"\"\"\n"+
+		"current,,current,605999f514798915490a1887aa255ea56393de07,hash,,kept,checked out in /src/repo\n",
+		out.String())
+
+	// No branches is still a valid document
+	out.Reset()
+	require.NoError(t, WriteRecords(&out, OutputJSON, nil))
+	assert.Equal(t, "[]\n", out.String())
+
+	out.Reset()
+	require.NoError(t, WriteRecords(&out, OutputCSV, nil))
+	assert.Equal(t, "name,remote,short,hash,reason,masters,result,error\n", out.String())
+
+	require.Error(t, WriteRecords(&out, OutputText, records))
+}
+
+func TestBranchDetails_Format(t *testing.T) {
+	r := newTestRepo(t)
+	hash := r.commit("Add the feature\n\nWith a longer description.", map[string]string{"README.md": "hello\n"})
+
+	branch := MergedBranch{BranchInfo: BranchInfo{Name: "origin/feature", Hash: hash, Remote: "origin", Short: "feature"}}
+	details, err := GetBranchDetails(r.repo, branch)
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Doe", details.Author)
+	assert.Equal(t, "jane@example.com", details.AuthorEmail)
+	assert.Equal(t, "Add the feature", details.Subject)
+	assert.True(t, details.Date.Equal(r.clock))
+	details.Result = ResultDeleted
+
+	tmpl, err := ParseFormat(`{{.Short}} {{.Hash}} {{.Author}} {{.Date.Format "2006-01-02"}} {{.Result}}`)
+	require.NoError(t, err)
+
+	var out bytes.Buffer
+	require.NoError(t, WriteFormatted(&out, tmpl, []BranchDetails{details, details}))
+	line := "feature " + hash.String() + " Jane Doe 2020-01-01 deleted\n"
+	assert.Equal(t, line+line, out.String())
+
+	_, err = ParseFormat("{{.Nope}}")
+	require.Error(t, err)
+	_, err = ParseFormat("{{.Short")
+	require.Error(t, err)
+
+	// Errors depending on the branch only show when formatting it
+	tmpl, err = ParseFormat("{{index .Masters 0}}")
+	require.NoError(t, err)
+	require.Error(t, WriteFormatted(&out, tmpl, []BranchDetails{details}))
+
+	_, err = GetBranchDetails(r.repo, MergedBranch{BranchInfo: BranchInfo{Name: "gone", Hash: plumbing.ZeroHash}})
+	require.Error(t, err)
+}
+
+func TestRelativeAge(t *testing.T) {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	for age, expected := range map[time.Duration]string{
+		-time.Hour:           "in the future",
+		30 * time.Second:     "just now",
+		time.Minute:          "1 minute ago",
+		45 * time.Minute:     "45 minutes ago",
+		5 * time.Hour:        "5 hours ago",
+		24 * time.Hour:       "1 day ago",
+		13 * 24 * time.Hour:  "13 days ago",
+		20 * 24 * time.Hour:  "2 weeks ago",
+		100 * 24 * time.Hour: "3 months ago",
+		800 * 24 * time.Hour: "2 years ago",
+	} {
+		assert.Equal(t, expected, RelativeAge(now.Add(-age), now), age.String())
+	}
+}
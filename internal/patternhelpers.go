@@ -0,0 +1,139 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+)
+
+// Prefixes selecting how a branch pattern matches.
+const (
+	// GlobPrefix marks a glob pattern, such as "glob:release/*", matched with
+	// path.Match so that "*" does not cross a "/".
+	GlobPrefix = "glob:"
+	// RegexpPrefix marks a regular expression, such as "re:^env/", which matches
+	// anywhere in the name unless anchored.
+	RegexpPrefix = "re:"
+)
+
+// BranchPatterns is a list of patterns matched against short branch names.
+type BranchPatterns []branchPattern
+
+// branchPattern is one parsed pattern, matching exactly, as a glob or as a
+// regular expression.
+type branchPattern struct {
+	source string
+	glob   string
+	re     *regexp.Regexp
+}
+
+// ParseBranchPatterns parses a comma-separated list of branch patterns. Patterns
+// prefixed with GlobPrefix are globs and ones prefixed with RegexpPrefix are
+// regular expressions. Other patterns match the branch name exactly, unless they
+// contain "*", "?" or "[", which branch names cannot, and are then globs too.
+func ParseBranchPatterns(list string) (BranchPatterns, error) {
+	var patterns BranchPatterns
+
+	for _, source := range strings.Split(list, ",") {
+		source = strings.TrimSpace(source)
+		if source == "" {
+			continue
+		}
+
+		pattern := branchPattern{source: source}
+		switch {
+		case strings.HasPrefix(source, RegexpPrefix):
+			re, err := regexp.Compile(strings.TrimPrefix(source, RegexpPrefix))
+			if err != nil {
+				return nil, fmt.Errorf("invalid branch pattern %q: %w", source, err)
+			}
+			pattern.re = re
+		case strings.HasPrefix(source, GlobPrefix):
+			pattern.glob = strings.TrimPrefix(source, GlobPrefix)
+		case strings.ContainsAny(source, "*?["):
+			pattern.glob = source
+		}
+
+		if pattern.glob != "" {
+			if _, err := path.Match(pattern.glob, ""); err != nil {
+				return nil, fmt.Errorf("invalid branch pattern %q: %w", source, err)
+			}
+		}
+
+		patterns = append(patterns, pattern)
+	}
+
+	return patterns, nil
+}
+
+// Match returns the first pattern matching the branch name, as it was given, and
+// whether any did.
+func (p BranchPatterns) Match(name string) (string, bool) {
+	for _, pattern := range p {
+		if pattern.matches(name) {
+			return pattern.source, true
+		}
+	}
+	return "", false
+}
+
+// String returns the patterns as they were given, comma-separated.
+func (p BranchPatterns) String() string {
+	sources := make([]string, len(p))
+	for i, pattern := range p {
+		sources[i] = pattern.source
+	}
+	return strings.Join(sources, ",")
+}
+
+func (p branchPattern) matches(name string) bool {
+	switch {
+	case p.re != nil:
+		return p.re.MatchString(name)
+	case p.glob != "":
+		// The pattern was checked when parsed, so it cannot fail here
+		ok, _ := path.Match(p.glob, name)
+		return ok
+	}
+	return p.source == name
+}
+
+// branchFilter decides which branches are looked at by their short names: ones
+// matching a skip pattern are left out, and when only patterns are given, so are
+// the ones matching none of them.
+type branchFilter struct {
+	skip BranchPatterns
+	only BranchPatterns
+}
+
+// newBranchFilter parses the comma-separated skip and only pattern lists.
+func newBranchFilter(skip, only string) (branchFilter, error) {
+	var filter branchFilter
+	var err error
+
+	if filter.skip, err = ParseBranchPatterns(skip); err != nil {
+		return branchFilter{}, fmt.Errorf("parsing the branches to skip failed: %w", err)
+	}
+	if filter.only, err = ParseBranchPatterns(only); err != nil {
+		return branchFilter{}, fmt.Errorf("parsing the only branches to check failed: %w", err)
+	}
+	return filter, nil
+}
+
+// excludes tells whether the branch, shown as name, is left out, logging the
+// reason when it is.
+func (f branchFilter) excludes(name, short string) bool {
+	if pattern, ok := f.skip.Match(short); ok {
+		LogInfof("Branch '%s' matches skip branch string '[%s]'", name, pattern)
+		return true
+	}
+
+	if len(f.only) > 0 {
+		if _, ok := f.only.Match(short); !ok {
+			LogInfof("Branch '%s' matches no only branch string '[%s]'", name, f.only)
+			return true
+		}
+	}
+	return false
+}
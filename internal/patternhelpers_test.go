@@ -0,0 +1,56 @@
+package internal
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBranchPatterns(t *testing.T) {
+	patterns, err := ParseBranchPatterns("keep, release/*,glob:hotfix-?,re:^env/,,")
+	require.NoError(t, err)
+	assert.Equal(t, "keep,release/*,glob:hotfix-?,re:^env/", patterns.String())
+
+	for name, expected := range map[string]string{
+		"keep":          "keep",
+		"release/1.0":   "release/*",
+		"hotfix-1":      "glob:hotfix-?",
+		"env/staging":   "re:^env/",
+		"keeper":        "",
+		"release/1/fix": "",
+		"hotfix-12":     "",
+		"my-env/x":      "",
+	} {
+		pattern, ok := patterns.Match(name)
+		assert.Equal(t, expected != "", ok, name)
+		assert.Equal(t, expected, pattern, name)
+	}
+
+	patterns, err = ParseBranchPatterns("")
+	require.NoError(t, err)
+	assert.Empty(t, patterns)
+
+	_, err = ParseBranchPatterns("re:(")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `invalid branch pattern "re:("`)
+
+	_, err = ParseBranchPatterns("glob:[")
+	require.Error(t, err)
+}
+
+func TestBranchFilter(t *testing.T) {
+	filter, err := newBranchFilter("feature/keep", "feature/*")
+	require.NoError(t, err)
+
+	assert.False(t, filter.excludes("origin/feature/x", "feature/x"))
+	assert.True(t, filter.excludes("origin/feature/keep", "feature/keep"))
+	assert.True(t, filter.excludes("origin/bugfix", "bugfix"))
+
+	filter, err = newBranchFilter("", "")
+	require.NoError(t, err)
+	assert.False(t, filter.excludes("anything", "anything"))
+
+	_, err = newBranchFilter("", "re:[")
+	require.Error(t, err)
+}
@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// PlanVersion is the version of the plan file format written by WritePlan.
+const PlanVersion = 1
+
+// Plan is a reviewable list of branches to delete, written by `gitsweeper plan`
+// and carried out by `gitsweeper apply`.
+type Plan struct {
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+	// Repository identifies the repository the plan was made for, see
+	// RepositoryIdentity.
+	Repository string `json:"repository"`
+	Remote     string `json:"remote"`
+	// Local plans delete local branches rather than the remote's.
+	Local    bool         `json:"local,omitempty"`
+	Masters  []string     `json:"masters"`
+	Branches []PlanBranch `json:"branches"`
+}
+
+// PlanBranch is a branch a plan deletes, along with the commit it must still
+// point at when the plan is applied.
+type PlanBranch struct {
+	Name     string            `json:"name"`
+	Remote   string            `json:"remote,omitempty"`
+	Short    string            `json:"short"`
+	Hash     string            `json:"hash"`
+	Strategy DetectionStrategy `json:"strategy"`
+	Masters  []string          `json:"masters"`
+}
+
+// NewPlan returns a plan deleting the merged branches found with opts.
+func NewPlan(repo *git.Repository, opts MergedBranchesOptions, branches []MergedBranch) (Plan, error) {
+	identity, err := RepositoryIdentity(repo, opts.Remote, opts.Local)
+	if err != nil {
+		return Plan{}, err
+	}
+
+	plan := Plan{
+		Version:    PlanVersion,
+		Created:    time.Now().UTC(),
+		Repository: identity,
+		Remote:     opts.Remote,
+		Local:      opts.Local,
+		Masters:    opts.Masters,
+		Branches:   make([]PlanBranch, 0, len(branches)),
+	}
+
+	for _, branch := range branches {
+		plan.Branches = append(plan.Branches, PlanBranch{
+			Name:     branch.Name,
+			Remote:   branch.Remote,
+			Short:    branch.Short,
+			Hash:     branch.Hash.String(),
+			Strategy: branch.Strategy,
+			Masters:  branch.Masters,
+		})
+	}
+
+	return plan, nil
+}
+
+// WritePlan writes the plan as indented JSON to path.
+func WritePlan(plan Plan, path string) error {
+	data, err := json.MarshalIndent(plan, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding plan failed: %w", err)
+	}
+
+	if err = os.WriteFile(path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing plan failed: %w", err)
+	}
+	return nil
+}
+
+// ReadPlan reads a plan written by WritePlan.
+func ReadPlan(path string) (Plan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Plan{}, fmt.Errorf("reading plan failed: %w", err)
+	}
+
+	var plan Plan
+	if err = json.Unmarshal(data, &plan); err != nil {
+		return Plan{}, fmt.Errorf("parsing plan %s failed: %w", path, err)
+	}
+
+	if plan.Version != PlanVersion {
+		return Plan{}, fmt.Errorf("plan %s has version %d, only version %d is supported", path, plan.Version, PlanVersion)
+	}
+	return plan, nil
+}
+
+// VerifyPlan checks that the plan was made for repo and that every branch it
+// deletes still points at the commit recorded in it, and returns the branches.
+// A plan with any branch that moved or disappeared since is stale, and refused
+// as a whole rather than carried out in part.
+func VerifyPlan(repo *git.Repository, plan Plan) ([]MergedBranch, error) {
+	identity, err := RepositoryIdentity(repo, plan.Remote, plan.Local)
+	if err != nil {
+		return nil, err
+	}
+	if identity != plan.Repository {
+		return nil, fmt.Errorf("the plan was made for %s, not %s", plan.Repository, identity)
+	}
+
+	var stale []string
+	branches := make([]MergedBranch, 0, len(plan.Branches))
+
+	for _, planned := range plan.Branches {
+		refName := plumbing.NewRemoteReferenceName(plan.Remote, planned.Short)
+		if plan.Local {
+			refName = plumbing.NewBranchReferenceName(planned.Short)
+		}
+
+		ref, refErr := repo.Reference(refName, false)
+		switch {
+		case refErr != nil:
+			stale = append(stale, fmt.Sprintf("%s no longer exists", planned.Name))
+			continue
+		case ref.Hash().String() != planned.Hash:
+			stale = append(stale, fmt.Sprintf("%s moved from %s to %s",
+				planned.Name, shortHash(planned.Hash), shortHash(ref.Hash().String())))
+			continue
+		}
+
+		branches = append(branches, MergedBranch{
+			BranchInfo: BranchInfo{
+				Name:   planned.Name,
+				Hash:   ref.Hash(),
+				Remote: planned.Remote,
+				Short:  planned.Short,
+			},
+			Strategy: planned.Strategy,
+			Masters:  planned.Masters,
+		})
+	}
+
+	if len(stale) > 0 {
+		return nil, fmt.Errorf("the plan is stale, make a new one:\n  %s", strings.Join(stale, "\n  "))
+	}
+
+	return branches, nil
+}
+
+// RepositoryIdentity identifies the repository a plan applies to: the URL of
+// remote for remote branches, or the path of the repository for local ones.
+func RepositoryIdentity(repo *git.Repository, remote string, local bool) (string, error) {
+	if local {
+		storage, ok := repo.Storer.(*filesystem.Storage)
+		if !ok {
+			return "", errors.New("plans for local branches need a repository on disk")
+		}
+
+		commonDir, err := gitCommonDir(storage.Filesystem().Root())
+		if err != nil {
+			return "", err
+		}
+		return filepath.Abs(commonDir)
+	}
+
+	gitRemote, err := repo.Remote(remote)
+	if err != nil {
+		return "", fmt.Errorf("Could not find the remote named %s", remote)
+	}
+	if len(gitRemote.Config().URLs) == 0 {
+		return "", fmt.Errorf("remote %s has no URL", remote)
+	}
+	return gitRemote.Config().URLs[0], nil
+}
+
+// shortHash abbreviates a hash for messages.
+func shortHash(hash string) string {
+	if len(hash) > 7 {
+		return hash[:7]
+	}
+	return hash
+}
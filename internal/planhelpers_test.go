@@ -0,0 +1,79 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlan(t *testing.T) {
+	r := newTestRepo(t)
+	base := r.commit("initial", map[string]string{"README.md": "hello\n"})
+	r.setRef("refs/remotes/origin/master", base)
+	r.setRef("refs/remotes/origin/feature-a", base)
+	r.setRef("refs/remotes/origin/feature-b", base)
+
+	opts := MergedBranchesOptions{Remote: "origin", Masters: []string{"master"}}
+	merged, err := GetMergedBranches(r.repo, opts)
+	require.NoError(t, err)
+	require.Len(t, merged, 2)
+
+	plan, err := NewPlan(r.repo, opts, merged)
+	require.NoError(t, err)
+	assert.Equal(t, "https://example.com/repo.git", plan.Repository)
+
+	path := filepath.Join(t.TempDir(), "plan.json")
+	require.NoError(t, WritePlan(plan, path))
+
+	read, err := ReadPlan(path)
+	require.NoError(t, err)
+	assert.Equal(t, plan.Branches, read.Branches)
+	assert.True(t, plan.Created.Equal(read.Created))
+
+	branches, err := VerifyPlan(r.repo, read)
+	require.NoError(t, err)
+	assert.Equal(t, merged, branches)
+
+	// A branch pushed to since makes the whole plan stale
+	moved := r.commit("more work", map[string]string{"README.md": "hello world\n"})
+	r.setRef("refs/remotes/origin/feature-b", moved)
+
+	_, err = VerifyPlan(r.repo, read)
+	require.EqualError(t, err, "the plan is stale, make a new one:\n  origin/feature-b moved from "+
+		base.String()[:7]+" to "+moved.String()[:7])
+
+	require.NoError(t, r.repo.Storer.RemoveReference("refs/remotes/origin/feature-b"))
+	_, err = VerifyPlan(r.repo, read)
+	require.EqualError(t, err, "the plan is stale, make a new one:\n  origin/feature-b no longer exists")
+}
+
+func TestVerifyPlan_OtherRepository(t *testing.T) {
+	r := newTestRepo(t)
+	_, err := r.repo.CreateRemote(&config.RemoteConfig{Name: "fork", URLs: []string{"https://example.com/fork.git"}})
+	require.NoError(t, err)
+
+	plan := Plan{Version: PlanVersion, Repository: "https://example.com/repo.git", Remote: "fork"}
+	_, err = VerifyPlan(r.repo, plan)
+	require.EqualError(t, err, "the plan was made for https://example.com/repo.git, not https://example.com/fork.git")
+}
+
+func TestReadPlan_Invalid(t *testing.T) {
+	dir := t.TempDir()
+
+	path := filepath.Join(dir, "future.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{"version": 2}`), 0o600))
+	_, err := ReadPlan(path)
+	require.EqualError(t, err, "plan "+path+" has version 2, only version 1 is supported")
+
+	path = filepath.Join(dir, "broken.json")
+	require.NoError(t, os.WriteFile(path, []byte(`{`), 0o600))
+	_, err = ReadPlan(path)
+	require.Error(t, err)
+
+	_, err = ReadPlan(filepath.Join(dir, "missing.json"))
+	require.Error(t, err)
+}
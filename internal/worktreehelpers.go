@@ -0,0 +1,106 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+)
+
+// checkedOutBranches maps every local branch checked out in a worktree of the
+// repository to the path of that worktree. Besides the current worktree, this
+// covers the main worktree and each linked one listed in .git/worktrees, as
+// created by `git worktree add`. Branches being rebased count as checked out,
+// like git itself treats them.
+func checkedOutBranches(repo *git.Repository) (map[string]string, error) {
+	branches := make(map[string]string)
+
+	if current := currentBranch(repo); current != "" {
+		dir, err := repoDir(repo)
+		if err != nil {
+			return nil, err
+		}
+		branches[current] = dir
+	}
+
+	storage, ok := repo.Storer.(*filesystem.Storage)
+	if !ok {
+		return branches, nil
+	}
+
+	commonDir, err := gitCommonDir(storage.Filesystem().Root())
+	if err != nil {
+		return nil, err
+	}
+
+	// The main worktree, unless the repository is bare
+	if filepath.Base(commonDir) == git.GitDirName {
+		addWorktreeBranch(branches, commonDir, filepath.Dir(commonDir))
+	}
+
+	entries, err := os.ReadDir(filepath.Join(commonDir, "worktrees"))
+	if errors.Is(err, os.ErrNotExist) {
+		return branches, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("listing worktrees failed: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+
+		adminDir := filepath.Join(commonDir, "worktrees", entry.Name())
+		worktreePath := entry.Name()
+		if gitdir, readErr := os.ReadFile(filepath.Join(adminDir, "gitdir")); readErr == nil {
+			worktreePath = filepath.Dir(strings.TrimSpace(string(gitdir)))
+		}
+
+		addWorktreeBranch(branches, adminDir, worktreePath)
+	}
+
+	return branches, nil
+}
+
+// gitCommonDir returns the directory holding the objects, refs and worktree list
+// shared by all worktrees. For a linked worktree, gitDir contains a commondir
+// file pointing at it; otherwise gitDir is the common directory itself.
+func gitCommonDir(gitDir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if errors.Is(err, os.ErrNotExist) {
+		return gitDir, nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("reading commondir failed: %w", err)
+	}
+
+	commonDir := strings.TrimSpace(string(data))
+	if !filepath.IsAbs(commonDir) {
+		commonDir = filepath.Join(gitDir, commonDir)
+	}
+	return filepath.Clean(commonDir), nil
+}
+
+// addWorktreeBranch records the branch checked out by the worktree whose
+// administrative files are in adminDir, if it has one.
+func addWorktreeBranch(branches map[string]string, adminDir, worktreePath string) {
+	for _, name := range []string{"HEAD", "rebase-merge/head-name", "rebase-apply/head-name"} {
+		data, err := os.ReadFile(filepath.Join(adminDir, name))
+		if err != nil {
+			continue
+		}
+
+		ref := strings.TrimPrefix(strings.TrimSpace(string(data)), "ref: ")
+		if branch, ok := strings.CutPrefix(ref, "refs/heads/"); ok {
+			if _, seen := branches[branch]; !seen {
+				LogInfof("Branch '%s' is checked out in worktree %s", branch, worktreePath)
+				branches[branch] = worktreePath
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package internal
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckedOutBranches(t *testing.T) {
+	d := newDiskRepo(t)
+	d.commit("initial")
+
+	worktreeDir := filepath.Join(t.TempDir(), "feature-wt")
+	output, err := runGit(d.repo, DeleteTimeout, "worktree", "add", "-b", "feature", worktreeDir)
+	require.NoError(t, err, output)
+	output, err = runGit(d.repo, DeleteTimeout, "branch", "idle")
+	require.NoError(t, err, output)
+
+	checkedOut, err := checkedOutBranches(d.repo)
+	require.NoError(t, err)
+	assert.Len(t, checkedOut, 2)
+	assert.Equal(t, "clone", filepath.Base(checkedOut["master"]))
+	assert.Equal(t, "feature-wt", filepath.Base(checkedOut["feature"]))
+
+	err = DeleteLocalBranch(d.repo, "feature")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "branch feature is checked out in worktree")
+	require.NoError(t, DeleteLocalBranch(d.repo, "idle"))
+
+	// The same branches are found when working in the linked worktree
+	linked, err := git.PlainOpenWithOptions(worktreeDir, &git.PlainOpenOptions{EnableDotGitCommonDir: true})
+	require.NoError(t, err)
+
+	checkedOut, err = checkedOutBranches(linked)
+	require.NoError(t, err)
+	assert.Len(t, checkedOut, 2)
+	assert.Equal(t, "clone", filepath.Base(checkedOut["master"]))
+	assert.Equal(t, "feature-wt", filepath.Base(checkedOut["feature"]))
+}
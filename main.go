@@ -1,10 +1,20 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+	"time"
 
+	"github.com/go-git/go-git/v5"
 	hlpr "github.com/petems/gitsweeper/internal"
 )
 
@@ -14,166 +24,1394 @@ const Version = "0.1.0"
 // gitCommit is the gitcommit its built from.
 var gitCommit = "development"
 
+// stdin is shared by every prompt, so input buffered while answering one
+// question is not lost to the next.
+var stdin = bufio.NewReader(os.Stdin)
+
+// progress receives the messages meant for people, which are discarded when the
+// output is structured so that standard output only holds the records.
+var progress io.Writer = os.Stdout
+
+// exitPartial is the exit code when some of the branches could not be deleted.
+const exitPartial = 2
+
+// output is how preview and cleanup report branches: as prose, as structured
+// records, or each with a template.
+type output struct {
+	format   hlpr.OutputFormat
+	template *template.Template
+}
+
+// text tells whether the branches are reported as prose.
+func (o output) text() bool {
+	return o.format == hlpr.OutputText && o.template == nil
+}
+
+// outcome is what became of a branch, for output other than prose.
+type outcome struct {
+	branch hlpr.MergedBranch
+	result string
+	err    error
+}
+
+// listFlag is a flag that may be repeated, each value holding one or more
+// comma-separated items.
+type listFlag []string
+
+func (l *listFlag) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *listFlag) Set(value string) error {
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			*l = append(*l, item)
+		}
+	}
+	return nil
+}
+
+// app holds the value of every flag, each command defining the ones it takes,
+// and the state of the command line once it is parsed.
+type app struct {
+	cli *hlpr.CLI
+
+	// flags are the flags of the command being run, given names the ones on the
+	// command line, and settings the values read from the configuration
+	flags    *flag.FlagSet
+	given    map[string]bool
+	settings *hlpr.Settings
+
+	debug   bool
+	help    bool
+	version bool
+
+	origin     string
+	masters    listFlag
+	candidates string
+	prefer     bool
+	all        bool
+	detect     string
+	skip       string
+	only       string
+	noFetch    bool
+
+	local   bool
+	older   string
+	newer   string
+	ageFrom string
+
+	force   bool
+	jobs    int
+	batch   int
+	archive bool
+	prefix  string
+
+	outFmt  string
+	outTmpl string
+	details bool
+	planOut string
+	run     string
+}
+
+// Exit codes, besides 0 for success and exitPartial.
+const (
+	// exitFailure is the exit code when the command failed.
+	exitFailure = 1
+	// exitUsage is the exit code when the flags, arguments or configuration are
+	// invalid, as EX_USAGE in sysexits.h.
+	exitUsage = 64
+)
+
+// exitStatus describes the exit codes in the usage.
+const exitStatus = `
+Exit status:
+  0   the command succeeded, even when there was nothing to delete
+  1   the command failed, such as outside a Git repository
+  2   some branches could not be deleted
+  64  the flags, arguments or configuration are invalid
+`
+
 func main() {
-	// Define command-line flags using standard library
-	var (
-		debug   = flag.Bool("debug", false, "Enable debug mode")
-		version = flag.Bool("version", false, "Show version")
-		help    = flag.Bool("help", false, "Show help")
-		origin  = flag.String("origin", "origin", "The name of the remote you wish to clean up")
-		master  = flag.String("master", "master", "The name of what you consider the master branch")
-		skip    = flag.String("skip", "", "Comma-separated list of branches to skip")
-		force   = flag.Bool("force", false, "Do not ask, cleanup immediately")
-	)
+	a := &app{}
+	a.cli = &hlpr.CLI{
+		Name:     "gitsweeper",
+		Summary:  "A command-line tool for cleaning up merged branches.",
+		Global:   a.globalFlags,
+		Commands: a.commands(),
+		NoEnv:    []string{"help", "version"},
+		Aliases:  map[string]string{"o": "plan-file"},
+	}
+	a.cli.Values = a.flagValues()
 
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "usage: gitsweeper [<flags>] <command> [<args> ...]\n\n")
-		fmt.Fprintf(os.Stderr, "A command-line tool for cleaning up merged branches.\n")
+	// The completion scripts call back with words that are not meant to be
+	// parsed as flags yet
+	if len(os.Args) > 1 && os.Args[1] == hlpr.CompleteCommand {
+		a.handleComplete(os.Args[2:])
+		return
 	}
 
-	// Parse flags before the command
-	flag.Parse()
+	inv, err := a.cli.Parse(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		// -h is not defined, so asks for help like --help
+		a.help, err = true, nil
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		if inv.Command != nil {
+			fmt.Fprintf(os.Stderr, "Run `gitsweeper help %s` for its usage\n", inv.Command.Name)
+		} else {
+			fmt.Fprintf(os.Stderr, "Run `gitsweeper help` for the usage\n")
+		}
+		os.Exit(exitUsage)
+	}
+	a.flags = inv.Flags
 
 	// Handle version flag
-	if *version {
+	if a.version {
 		fmt.Printf("%s %s\n", Version, gitCommit)
 		return
 	}
 
 	// Handle help flag
-	if *help || flag.NArg() == 0 {
-		flag.Usage()
+	if a.help || inv.Command == nil {
+		a.usage(os.Stdout, inv.Command)
+		return
+	}
+
+	// Flags given on the command line, before or after the command
+	a.given = a.cli.Given(a.flags)
+
+	// Flags not given are taken from the environment or the configuration, the
+	// only place where the precedence of the sources is settled
+	if !inv.Command.NoConfig {
+		a.settings = loadSettings(a.cli.Settable())
+		applySettings(a.flags, a.settings, a.given)
+	}
+
+	// Setup lightweight logger
+	hlpr.SetupLightLogger(a.debug)
+
+	inv.Command.Run(inv.Args)
+}
+
+// usage writes the usage of the command, or of the tool when cmd is nil.
+func (a *app) usage(w io.Writer, cmd *hlpr.Command) {
+	if cmd != nil {
+		a.cli.CommandUsage(w, cmd)
 		return
 	}
 
-	command := flag.Arg(0)
+	a.cli.Usage(w)
+	fmt.Fprint(w, exitStatus)
+}
+
+// commands returns the commands of the tool, in the order the usage lists them.
+func (a *app) commands() []*hlpr.Command {
+	return []*hlpr.Command{
+		{
+			Name:    "preview",
+			Summary: "List the branches merged into master, without deleting them",
+			Examples: []string{
+				"gitsweeper preview",
+				"gitsweeper preview --origin upstream --master main --details",
+				"gitsweeper preview --detect hash,squash,rebase --output json",
+			},
+			Flags: func(fs *flag.FlagSet) {
+				a.selectFlags(fs)
+				a.filterFlags(fs)
+				a.fetchFlag(fs)
+				a.outputFlags(fs)
+				a.detailsFlag(fs)
+			},
+			Run: func([]string) {
+				handlePreview(a.branchOptions(), a.candidates, !a.noFetch, a.output(false), a.details)
+			},
+		},
+		{
+			Name:    "cleanup",
+			Summary: "Delete the branches merged into master, asking first unless --force is given",
+			Examples: []string{
+				"gitsweeper cleanup",
+				"gitsweeper cleanup --force --skip 'release/*'",
+				"gitsweeper cleanup --local --older-than 14d",
+				"gitsweeper cleanup --archive --force --output json",
+			},
+			Flags: func(fs *flag.FlagSet) {
+				a.selectFlags(fs)
+				a.filterFlags(fs)
+				a.fetchFlag(fs)
+				a.forceFlag(fs)
+				a.deleteFlags(fs)
+				a.outputFlags(fs)
+			},
+			Run: func([]string) {
+				handleCleanup(a.branchOptions(), a.candidates, !a.noFetch, a.force, a.deleteOptions(), a.output(true))
+			},
+		},
+		{
+			Name:    "gone",
+			Summary: "Delete local branches whose upstream branch is gone from the remote",
+			Examples: []string{
+				"gitsweeper gone",
+				"gitsweeper gone --force --skip wip",
+			},
+			Flags: func(fs *flag.FlagSet) {
+				a.selectFlags(fs)
+				a.fetchFlag(fs)
+				a.forceFlag(fs)
+			},
+			Run: func([]string) {
+				handleGone(a.branchOptions(), a.candidates, !a.noFetch, a.force)
+			},
+		},
+		{
+			Name:    "plan",
+			Summary: "Write the branches cleanup would delete to a file, for review",
+			Examples: []string{
+				"gitsweeper plan",
+				"gitsweeper plan -o review.json --older-than 30d",
+			},
+			Flags: func(fs *flag.FlagSet) {
+				a.selectFlags(fs)
+				a.filterFlags(fs)
+				a.fetchFlag(fs)
+				a.planFlag(fs)
+			},
+			Run: func([]string) {
+				handlePlan(a.branchOptions(), a.candidates, !a.noFetch, a.planOut)
+			},
+		},
+		{
+			Name:    "apply",
+			Args:    "<plan>",
+			Summary: "Delete the branches of a plan, unless they moved since it was written",
+			Examples: []string{
+				"gitsweeper apply plan.json",
+				"gitsweeper apply plan.json --force --jobs 4",
+			},
+			MinArgs: 1,
+			MaxArgs: 1,
+			Flags: func(fs *flag.FlagSet) {
+				a.fetchFlag(fs)
+				a.forceFlag(fs)
+				a.deleteFlags(fs)
+			},
+			Run: func(args []string) {
+				handleApply(args[0], !a.noFetch, a.force, a.deleteOptions())
+			},
+		},
+		{
+			Name:    "restore",
+			Args:    "[<branch> ...]",
+			Summary: "Push the branches deleted in a run back, all of them or the ones named",
+			Examples: []string{
+				"gitsweeper restore",
+				"gitsweeper restore --run 20240102-100000-3f9a1c origin/feature-x --force",
+			},
+			MaxArgs: -1,
+			Flags: func(fs *flag.FlagSet) {
+				a.forceFlag(fs)
+				a.runFlag(fs)
+			},
+			ArgValues: a.journalBranches,
+			Run: func(args []string) {
+				handleRestore(a.run, args, a.force)
+			},
+		},
+		{
+			Name:    "config",
+			Args:    "show",
+			Summary: "Show the value of every setting and where it came from",
+			Examples: []string{
+				"gitsweeper config show",
+				"GITSWEEPER_ORIGIN=upstream gitsweeper config show --force",
+			},
+			MinArgs:   1,
+			MaxArgs:   1,
+			ArgValues: func() []string { return []string{"show"} },
+			Flags: func(fs *flag.FlagSet) {
+				a.selectFlags(fs)
+				a.filterFlags(fs)
+				a.fetchFlag(fs)
+				a.forceFlag(fs)
+				a.deleteFlags(fs)
+				a.outputFlags(fs)
+				a.detailsFlag(fs)
+				a.planFlag(fs)
+				a.runFlag(fs)
+			},
+			Run: func(args []string) {
+				if args[0] != "show" {
+					fmt.Fprintf(os.Stderr, "Error: unknown config subcommand %q, use `gitsweeper config show`\n", args[0])
+					os.Exit(exitUsage)
+				}
+				a.handleConfigShow()
+			},
+		},
+		{
+			Name:    "help",
+			Args:    "[<command>]",
+			Summary: "Show the usage of gitsweeper or of a command",
+			Examples: []string{
+				"gitsweeper help cleanup",
+			},
+			MaxArgs:   1,
+			ArgValues: a.commandNames,
+			NoConfig:  true,
+			Run:       a.handleHelp,
+		},
+		{
+			Name:    "completion",
+			Args:    "<shell>",
+			Summary: "Print a script completing commands, flags, remotes and branches in bash, zsh or fish",
+			Examples: []string{
+				"source <(gitsweeper completion bash)",
+				"gitsweeper completion zsh > \"${fpath[1]}/_gitsweeper\"",
+				"gitsweeper completion fish > ~/.config/fish/completions/gitsweeper.fish",
+			},
+			MinArgs:   1,
+			MaxArgs:   1,
+			ArgValues: func() []string { return hlpr.Shells },
+			NoConfig:  true,
+			Run:       a.handleCompletion,
+		},
+		{
+			Name:     "version",
+			Summary:  "Show the version",
+			NoConfig: true,
+			Run: func([]string) {
+				fmt.Printf("%s %s\n", Version, gitCommit)
+			},
+		},
+	}
+}
 
-	// Parse remaining flags after the command
-	if flag.NArg() > 1 {
-		// Create a new flag set for the remaining arguments
-		cmdFlags := flag.NewFlagSet("", flag.ExitOnError)
-		cmdFlags.Bool("force", false, "Do not ask, cleanup immediately")
-		cmdFlags.Bool("debug", false, "Enable debug mode")
-		cmdFlags.String("origin", "origin", "The name of the remote you wish to clean up")
-		cmdFlags.String("master", "master", "The name of what you consider the master branch")
-		cmdFlags.String("skip", "", "Comma-separated list of branches to skip")
+// globalFlags defines the flags every command takes.
+func (a *app) globalFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&a.debug, "debug", false, "Enable debug mode")
+	fs.BoolVar(&a.help, "help", false, "Show help")
+	fs.BoolVar(&a.version, "version", false, "Show version")
+}
 
-		// Parse the remaining arguments
-		if err := cmdFlags.Parse(flag.Args()[1:]); err != nil {
-			fmt.Fprintf(os.Stderr, "Error parsing command flags: %s\n", err)
-			os.Exit(1)
+// selectFlags defines the flags choosing the remote, the masters and the branches
+// checked against them.
+func (a *app) selectFlags(fs *flag.FlagSet) {
+	fs.StringVar(&a.origin, "origin", "origin", "The name of the remote you wish to clean up")
+	fs.Var(&a.masters, "master",
+		"The name of what you consider the master branch, may be repeated or a glob (default: detected from the remote)")
+	fs.StringVar(&a.candidates, "master-candidates", hlpr.DefaultMasterCandidates,
+		"Comma-separated branch names to try when the remote has no default branch")
+	fs.BoolVar(&a.prefer, "prefer-local", false, "Use the local master branch instead of the remote-tracking one")
+	fs.BoolVar(&a.all, "all-masters", false, "Only count branches merged into every master, not just one")
+	fs.StringVar(&a.detect, "detect", "hash", "Comma-separated merge detection strategies (hash, squash, rebase)")
+	fs.StringVar(&a.skip, "skip", "", "Comma-separated branches to skip, as names, globs or re:<regexp>")
+	fs.StringVar(&a.only, "only", "",
+		"Comma-separated branches to check, leaving out all others, as names, globs or re:<regexp>")
+}
+
+// filterFlags defines the flags choosing between local and remote branches and
+// filtering them by age.
+func (a *app) filterFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&a.local, "local", false, "Clean up local branches instead of the remote's branches")
+	fs.StringVar(&a.older, "older-than", "", "Only branches dated before this age or date, such as 14d or 2024-01-31")
+	fs.StringVar(&a.newer, "newer-than", "", "Only branches dated after this age or date, such as 14d or 2024-01-31")
+	fs.StringVar(&a.ageFrom, "age-from", "commit",
+		"What dates a branch: commit, the head's committer date, or merge, when the head became part of master")
+}
+
+// fetchFlag defines the flag skipping the fetch before looking at branches.
+func (a *app) fetchFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&a.noFetch, "no-fetch", false, "Use the remote-tracking branches as they are, without fetching first")
+}
+
+// forceFlag defines the flag skipping the question before deleting or restoring.
+func (a *app) forceFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&a.force, "force", false, "Do not ask, cleanup immediately")
+}
+
+// deleteFlags defines the flags tuning how branches are deleted.
+func (a *app) deleteFlags(fs *flag.FlagSet) {
+	fs.IntVar(&a.jobs, "jobs", 1, "How many pushes deleting remote branches may run at once")
+	fs.IntVar(&a.batch, "batch-size", hlpr.DeleteBatchSize,
+		"How many remote branches a single push deletes, 1 to push each branch on its own")
+	fs.BoolVar(&a.archive, "archive", false, "Push each branch head to the archive namespace before deleting it")
+	fs.StringVar(&a.prefix, "archive-prefix", hlpr.DefaultArchivePrefix,
+		"The ref namespace --archive pushes branch heads under, such as refs/tags/archive/")
+}
+
+// outputFlags defines the flags choosing how branches are reported.
+func (a *app) outputFlags(fs *flag.FlagSet) {
+	fs.StringVar(&a.outFmt, "output", "text", "How preview and cleanup report branches: text, json, yaml, csv or ndjson")
+	fs.StringVar(&a.outTmpl, "format", "",
+		"A Go template preview and cleanup print for each branch, such as '{{.Short}} {{.Author}}'")
+}
+
+// detailsFlag defines the flag showing the head commit of each branch.
+func (a *app) detailsFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&a.details, "details", false, "Show the author, date, age and subject of each branch's head commit")
+}
+
+// planFlag defines the flag naming the file plan writes.
+func (a *app) planFlag(fs *flag.FlagSet) {
+	fs.StringVar(&a.planOut, "plan-file", "plan.json", "The file plan writes the plan to")
+}
+
+// runFlag defines the flag choosing the run to restore.
+func (a *app) runFlag(fs *flag.FlagSet) {
+	fs.StringVar(&a.run, "run", "", "The cleanup run to restore branches from (default: the latest)")
+}
+
+// branchOptions returns the options choosing the branches to look at, exiting
+// when a flag is invalid.
+func (a *app) branchOptions() hlpr.MergedBranchesOptions {
+	strategies, err := hlpr.ParseDetectionStrategies(a.detect)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing --detect: %s\n", err)
+		os.Exit(exitUsage)
+	}
+
+	age := hlpr.AgeFilter{}
+	if age.From, err = hlpr.ParseAgeSource(a.ageFrom); err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing --age-from: %s\n", err)
+		os.Exit(exitUsage)
+	}
+	now := time.Now()
+	if a.older != "" {
+		if age.OlderThan, err = hlpr.ParseAgeCutoff(a.older, now); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing --older-than: %s\n", err)
+			os.Exit(exitUsage)
+		}
+	}
+	if a.newer != "" {
+		if age.NewerThan, err = hlpr.ParseAgeCutoff(a.newer, now); err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing --newer-than: %s\n", err)
+			os.Exit(exitUsage)
 		}
+	}
+
+	return hlpr.MergedBranchesOptions{
+		Remote:      a.origin,
+		Masters:     a.masters,
+		RequireAll:  a.all,
+		PreferLocal: a.prefer,
+		Local:       a.local,
+		Skip:        a.skip,
+		Only:        a.only,
+		Strategies:  strategies,
+		Age:         age,
+	}
+}
+
+// output returns how branches are reported, exiting when a flag is invalid.
+// Output other than prose cannot ask before deleting, so cleanup needs --force
+// with it.
+func (a *app) output(deletes bool) output {
+	var out output
+	var err error
 
-		// Update the original flags with command-specific flags
-		if cmdFlags.Lookup("force") != nil && cmdFlags.Lookup("force").Value.String() == "true" {
-			*force = true
+	out.format, err = hlpr.ParseOutputFormat(a.outFmt)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error parsing --output: %s\n", err)
+		os.Exit(exitUsage)
+	}
+	if a.outTmpl != "" {
+		if out.format != hlpr.OutputText {
+			fmt.Fprintf(os.Stderr, "Error: --format cannot be used with --output %s\n", out.format)
+			os.Exit(exitUsage)
+		}
+		out.template, err = hlpr.ParseFormat(a.outTmpl)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing --format: %s\n", err)
+			os.Exit(exitUsage)
 		}
-		if cmdFlags.Lookup("debug") != nil && cmdFlags.Lookup("debug").Value.String() == "true" {
-			*debug = true
+	}
+	if !out.text() {
+		if deletes && !a.force {
+			fmt.Fprintf(os.Stderr, "Error: --output and --format cannot ask before deleting, add --force\n")
+			os.Exit(exitUsage)
 		}
-		if cmdFlags.Lookup("origin") != nil && cmdFlags.Lookup("origin").Value.String() != "" {
-			*origin = cmdFlags.Lookup("origin").Value.String()
+		progress = io.Discard
+	}
+	return out
+}
+
+// deleteOptions returns how branches are deleted, exiting when a flag is invalid.
+func (a *app) deleteOptions() hlpr.DeleteOptions {
+	deleteOpts := hlpr.DeleteOptions{BatchSize: a.batch, Jobs: a.jobs}
+	if a.archive {
+		var err error
+		deleteOpts.ArchivePrefix, err = hlpr.ParseArchivePrefix(a.prefix)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error parsing --archive-prefix: %s\n", err)
+			os.Exit(exitUsage)
 		}
-		if cmdFlags.Lookup("master") != nil && cmdFlags.Lookup("master").Value.String() != "" {
-			*master = cmdFlags.Lookup("master").Value.String()
+	}
+	return deleteOpts
+}
+
+// loadSettings reads the configuration of the user and of the repository in the
+// working directory, if any, exiting on failure.
+func loadSettings(names []string) *hlpr.Settings {
+	// Outside a repository only the user's configuration applies
+	repo, err := hlpr.GetCurrentDirAsGitRepo()
+	if err != nil {
+		repo = nil
+	}
+
+	settings, err := hlpr.LoadSettings(repo, names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when reading the configuration: %s\n", err)
+		os.Exit(exitUsage)
+	}
+	return settings
+}
+
+// applySettings sets the flags of fs not given on the command line from the
+// settings, exiting when a value is invalid. Settings for flags the command does
+// not take are left alone.
+func applySettings(fs *flag.FlagSet, settings *hlpr.Settings, given map[string]bool) {
+	for _, setting := range settings.All() {
+		if given[setting.Name] || fs.Lookup(setting.Name) == nil {
+			continue
 		}
-		if cmdFlags.Lookup("skip") != nil && cmdFlags.Lookup("skip").Value.String() != "" {
-			*skip = cmdFlags.Lookup("skip").Value.String()
+
+		if err := fs.Set(setting.Name, setting.Value); err != nil {
+			fmt.Fprintf(os.Stderr, "Error in %s: invalid value %q for %s: %s\n",
+				setting.Source, setting.Value, setting.Name, err)
+			os.Exit(exitUsage)
 		}
 	}
+}
 
-	// Setup lightweight logger
-	hlpr.SetupLightLogger(*debug)
-
-	switch command {
-	case "preview":
-		handlePreview(*origin, *master, *skip)
-	case "cleanup":
-		handleCleanup(*origin, *master, *skip, *force)
-	case "version":
-		fmt.Printf("%s %s\n", Version, gitCommit)
-	default:
-		fmt.Fprintf(os.Stderr, "Unknown command: %s\n", command)
-		flag.Usage()
-		os.Exit(1)
+// handleHelp prints the usage of the named command, or of the tool.
+func (a *app) handleHelp(args []string) {
+	if len(args) == 0 {
+		a.usage(os.Stdout, nil)
+		return
+	}
+
+	cmd := a.cli.Lookup(args[0])
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Error: unknown command %q\n", args[0])
+		fmt.Fprintf(os.Stderr, "Run `gitsweeper help` for the usage\n")
+		os.Exit(exitUsage)
+	}
+	a.usage(os.Stdout, cmd)
+}
+
+// flagValues returns the completers of the values of flags.
+func (a *app) flagValues() map[string]func() []string {
+	strategies := []string{string(hlpr.StrategyHash), string(hlpr.StrategySquash), string(hlpr.StrategyRebase)}
+	formats := []string{
+		string(hlpr.OutputText), string(hlpr.OutputJSON), string(hlpr.OutputYAML),
+		string(hlpr.OutputCSV), string(hlpr.OutputNDJSON),
+	}
+	sources := []string{string(hlpr.AgeFromCommit), string(hlpr.AgeFromMerge)}
+
+	return map[string]func() []string{
+		"origin":   a.remoteNames,
+		"master":   a.branchNames,
+		"skip":     a.branchNames,
+		"only":     a.branchNames,
+		"detect":   func() []string { return strategies },
+		"output":   func() []string { return formats },
+		"age-from": func() []string { return sources },
+		"run":      a.journalRuns,
 	}
 }
 
-func handlePreview(origin, master, skipBranches string) {
+// completionRepo opens the repository in the working directory for completion,
+// returning nil outside of one.
+func completionRepo() *git.Repository {
 	repo, err := hlpr.GetCurrentDirAsGitRepo()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error: This is not a Git repository\n")
-		os.Exit(1)
+		return nil
+	}
+	return repo
+}
+
+// remoteNames completes the names of the remotes.
+func (a *app) remoteNames() []string {
+	repo := completionRepo()
+	if repo == nil {
+		return nil
+	}
+
+	names, err := hlpr.RemoteNames(repo)
+	if err != nil {
+		return nil
+	}
+	return names
+}
+
+// branchNames completes the names of the branches of the remote given with
+// --origin, and of the local ones with --local.
+func (a *app) branchNames() []string {
+	repo := completionRepo()
+	if repo == nil {
+		return nil
 	}
 
-	mergedBranches, err := hlpr.GetMergedBranches(repo, origin, master, skipBranches)
+	names, err := hlpr.BranchNames(repo, a.origin, a.local)
+	if err != nil {
+		return nil
+	}
+	return names
+}
+
+// journalRuns completes the runs in the deletion journal, the latest first.
+func (a *app) journalRuns() []string {
+	repo := completionRepo()
+	if repo == nil {
+		return nil
+	}
+
+	entries, err := hlpr.ReadJournal(repo)
+	if err != nil {
+		return nil
+	}
+
+	var runs []string
+	seen := make(map[string]bool)
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !seen[entries[i].Run] {
+			seen[entries[i].Run] = true
+			runs = append(runs, entries[i].Run)
+		}
+	}
+	return runs
+}
+
+// journalBranches completes the branches deleted in the run given with --run, or
+// in the latest one.
+func (a *app) journalBranches() []string {
+	repo := completionRepo()
+	if repo == nil {
+		return nil
+	}
+
+	entries, err := hlpr.ReadJournal(repo)
+	if err != nil {
+		return nil
+	}
+	_, entries, err = hlpr.SelectJournalRun(entries, a.run, nil)
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+// commandNames completes the names of the commands.
+func (a *app) commandNames() []string {
+	names := make([]string, 0, len(a.cli.Commands))
+	for _, cmd := range a.cli.Commands {
+		names = append(names, cmd.Name)
+	}
+	return names
+}
+
+// handleComplete prints the candidates for the last of words, the command line
+// the completion script was called with. Settings apply as they would if the
+// command was run, so that branches are completed from the configured remote.
+func (a *app) handleComplete(words []string) {
+	if len(words) > 0 {
+		// Leave out the program name
+		words = words[1:]
+	}
+
+	// Settings are set on the flags of config, which takes all of them, and
+	// flags on the command line then override them as they are parsed
+	if settings, err := hlpr.LoadSettings(completionRepo(), a.cli.Settable()); err == nil {
+		fs := a.cli.FlagSet(a.cli.Lookup("config"))
+		for _, setting := range settings.All() {
+			_ = fs.Set(setting.Name, setting.Value)
+		}
+	}
+
+	for _, candidate := range a.cli.Complete(words) {
+		fmt.Println(candidate)
+	}
+}
+
+// handleCompletion prints the completion script for the shell.
+func (a *app) handleCompletion(args []string) {
+	script, err := a.cli.CompletionScript(args[0])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(exitUsage)
+	}
+	fmt.Print(script)
+}
+
+// handleConfigShow prints the value of every setting and where it came from.
+func (a *app) handleConfigShow() {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(table, "SETTING\tVALUE\tSOURCE\n")
+
+	for _, name := range a.cli.Settable() {
+		value := a.flags.Lookup(name).Value.String()
+		if value == "" {
+			value = `""`
+		}
+
+		source := "default"
+		if setting, ok := a.settings.Get(name); ok {
+			source = setting.Source
+		}
+		if a.given[name] {
+			source = "flag --" + name
+		}
+
+		fmt.Fprintf(table, "%s\t%s\t%s\n", name, value, source)
+	}
+
+	if err := table.Flush(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error when writing the output: %s\n", err)
+		os.Exit(exitFailure)
+	}
+}
+
+// findMergedBranches opens the repository with openRepository and looks for
+// merged branches, exiting on failure.
+func findMergedBranches(
+	opts *hlpr.MergedBranchesOptions,
+	candidates string,
+	fetch bool,
+) (*git.Repository, []hlpr.MergedBranch) {
+	repo := openRepository(opts, candidates, fetch)
+
+	mergedBranches, err := hlpr.GetMergedBranches(repo, *opts)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error when looking for branches: %s\n", err)
-		os.Exit(1)
+		os.Exit(exitFailure)
+	}
+
+	return repo, mergedBranches
+}
+
+// openRepository opens the repository in the working directory and optionally
+// fetches from the remote, exiting on failure. When no master branch was given,
+// the remote's default branch is detected and stored in opts.
+func openRepository(opts *hlpr.MergedBranchesOptions, candidates string, fetch bool) *git.Repository {
+	repo, err := hlpr.GetCurrentDirAsGitRepo()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: This is not a Git repository\n")
+		os.Exit(exitFailure)
+	}
+
+	if fetch {
+		fmt.Fprintln(progress, "Fetching from the remote...")
+		if err = hlpr.FetchRemote(repo, opts.Remote); err != nil {
+			fmt.Fprintf(os.Stderr, "Error when fetching from the remote: %s\n", err)
+			os.Exit(exitFailure)
+		}
+	}
+
+	if len(opts.Masters) == 0 {
+		names := strings.Split(candidates, ",")
+		master, detectErr := hlpr.DetectMasterBranch(repo, opts.Remote, names)
+		if detectErr != nil {
+			fmt.Fprintf(os.Stderr, "Error when looking for branches: %s\n", detectErr)
+			os.Exit(exitFailure)
+		}
+		opts.Masters = []string{master}
+
+		// The other candidates are likely long-lived branches too, so a guess
+		// must not have them deleted
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				opts.Protected = append(opts.Protected, name)
+			}
+		}
+	}
+
+	return repo
+}
+
+func handlePreview(opts hlpr.MergedBranchesOptions, candidates string, fetch bool, out output, details bool) {
+	repo, mergedBranches := findMergedBranches(&opts, candidates, fetch)
+	mergedBranches, checkedOut := splitCheckedOut(mergedBranches)
+
+	if !out.text() {
+		outcomes := make([]outcome, 0, len(mergedBranches)+len(checkedOut))
+		for _, branch := range mergedBranches {
+			outcomes = append(outcomes, outcome{branch: branch, result: hlpr.ResultMerged})
+		}
+		writeOutcomes(repo, out, append(outcomes, keptOutcomes(checkedOut)...))
+		return
 	}
 
 	if len(mergedBranches) == 0 {
-		fmt.Println("No remote branches are available for cleaning up")
+		fmt.Printf("No %s branches are available for cleaning up\n", branchKind(opts))
 	} else {
-		fmt.Println("\nThese branches have been merged into master:")
-		for _, branchName := range mergedBranches {
-			fmt.Printf("  %s\n", branchName)
+		fmt.Printf("\nThese branches have been merged into %s:\n", strings.Join(opts.Masters, ", "))
+		if details {
+			printBranchDetails(repo, mergedBranches, opts.Masters)
+		} else {
+			for _, branch := range mergedBranches {
+				fmt.Printf("  %s\n", describeBranch(branch, opts.Masters))
+			}
 		}
+	}
+
+	printCheckedOut(checkedOut)
+
+	if len(mergedBranches) > 0 {
 		fmt.Println("\nTo delete them, run again with `gitsweeper cleanup`")
 	}
 }
 
-func handleCleanup(origin, master, skipBranches string, force bool) {
+func handleCleanup(
+	opts hlpr.MergedBranchesOptions,
+	candidates string,
+	fetch, force bool,
+	deleteOpts hlpr.DeleteOptions,
+	out output,
+) {
+	repo, mergedBranches := findMergedBranches(&opts, candidates, fetch)
+	mergedBranches, checkedOut := splitCheckedOut(mergedBranches)
+
+	if !out.text() {
+		// Output other than prose is only allowed with --force, so nobody is asked
+		var errs []error
+		var journalErr error
+		if len(mergedBranches) > 0 {
+			errs, journalErr = deleteMergedBranches(repo, opts, mergedBranches, deleteOpts)
+		}
+
+		outcomes := make([]outcome, 0, len(mergedBranches)+len(checkedOut))
+		for i, branch := range mergedBranches {
+			outcomes = append(outcomes, outcome{branch: branch, result: deletionResult(errs[i], deleteOpts), err: errs[i]})
+		}
+		writeOutcomes(repo, out, append(outcomes, keptOutcomes(checkedOut)...))
+		exitOnFailures(errs, journalErr)
+		return
+	}
+
+	if len(mergedBranches) == 0 {
+		fmt.Printf("No %s branches are available for cleaning up\n", branchKind(opts))
+		printCheckedOut(checkedOut)
+		return
+	}
+
+	fmt.Printf("\nThese branches have been merged into %s:\n", strings.Join(opts.Masters, ", "))
+	for _, branch := range mergedBranches {
+		fmt.Printf("  %s\n", describeBranch(branch, opts.Masters))
+	}
+
+	printCheckedOut(checkedOut)
+
+	if !force && !confirm("Delete these branches?") {
+		fmt.Printf("OK, aborting.\n")
+		return
+	}
+
+	exitOnFailures(deleteMergedBranches(repo, opts, mergedBranches, deleteOpts))
+}
+
+func handlePlan(opts hlpr.MergedBranchesOptions, candidates string, fetch bool, path string) {
+	repo, mergedBranches := findMergedBranches(&opts, candidates, fetch)
+	mergedBranches, checkedOut := splitCheckedOut(mergedBranches)
+
+	if len(mergedBranches) == 0 {
+		fmt.Printf("No %s branches are available for cleaning up\n", branchKind(opts))
+		printCheckedOut(checkedOut)
+		return
+	}
+
+	fmt.Printf("\nThese branches have been merged into %s:\n", strings.Join(opts.Masters, ", "))
+	for _, branch := range mergedBranches {
+		fmt.Printf("  %s\n", describeBranch(branch, opts.Masters))
+	}
+
+	printCheckedOut(checkedOut)
+
+	plan, err := hlpr.NewPlan(repo, opts, mergedBranches)
+	if err == nil {
+		err = hlpr.WritePlan(plan, path)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when writing the plan: %s\n", err)
+		os.Exit(exitFailure)
+	}
+
+	fmt.Printf("\nWrote the plan to %s, to delete them run `gitsweeper apply %s`\n", path, path)
+}
+
+func handleApply(path string, fetch, force bool, deleteOpts hlpr.DeleteOptions) {
+	plan, err := hlpr.ReadPlan(path)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(exitFailure)
+	}
+
 	repo, err := hlpr.GetCurrentDirAsGitRepo()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: This is not a Git repository\n")
-		os.Exit(1)
+		os.Exit(exitFailure)
+	}
+
+	if fetch && !plan.Local {
+		fmt.Println("Fetching from the remote...")
+		if err = hlpr.FetchRemote(repo, plan.Remote); err != nil {
+			fmt.Fprintf(os.Stderr, "Error when fetching from the remote: %s\n", err)
+			os.Exit(exitFailure)
+		}
 	}
 
-	mergedBranches, err := hlpr.GetMergedBranches(repo, origin, master, skipBranches)
+	mergedBranches, err := hlpr.VerifyPlan(repo, plan)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "Error when looking for branches: %s\n", err)
-		os.Exit(1)
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(exitFailure)
 	}
 
 	if len(mergedBranches) == 0 {
-		fmt.Println("No remote branches are available for cleaning up")
+		fmt.Printf("The plan has no branches to delete\n")
 		return
 	}
 
-	fmt.Println("\nThese branches have been merged into master:")
-	for _, branchName := range mergedBranches {
-		fmt.Printf("  %s\n", branchName)
+	fmt.Printf("\nThe plan made at %s deletes these branches, merged into %s:\n",
+		plan.Created.Local().Format("2006-01-02 15:04"), strings.Join(plan.Masters, ", "))
+	for _, branch := range mergedBranches {
+		fmt.Printf("  %s\n", describeBranch(branch, plan.Masters))
+	}
+
+	if !force && !confirm("Delete these branches?") {
+		fmt.Printf("OK, aborting.\n")
+		return
 	}
 
-	if !force {
-		confirmDeleteBranches, confirmErr := hlpr.AskForConfirmation("Delete these branches?", os.Stdin)
-		if confirmErr != nil {
-			hlpr.LogFatalError("\nError when awaiting input", confirmErr)
+	opts := hlpr.MergedBranchesOptions{Remote: plan.Remote, Local: plan.Local}
+	exitOnFailures(deleteMergedBranches(repo, opts, mergedBranches, deleteOpts))
+}
+
+func handleGone(opts hlpr.MergedBranchesOptions, candidates string, fetch, force bool) {
+	repo := openRepository(&opts, candidates, fetch)
+
+	goneBranches, err := hlpr.GetGoneBranches(repo, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when looking for branches: %s\n", err)
+		os.Exit(exitFailure)
+	}
+
+	var merged, unpushed []hlpr.GoneBranch
+	var checkedOut []hlpr.MergedBranch
+	for _, branch := range goneBranches {
+		if branch.CheckedOutIn != "" {
+			checkedOut = append(checkedOut, branch.MergedBranch)
+		} else if branch.Merged {
+			merged = append(merged, branch)
+		} else {
+			unpushed = append(unpushed, branch)
+		}
+	}
+
+	if len(goneBranches) == 0 {
+		fmt.Printf("No local branches track a branch that is gone from %s\n", opts.Remote)
+		return
+	}
+
+	if len(merged) > 0 {
+		fmt.Printf("\nThese branches track a branch gone from %s and have been merged into %s:\n",
+			opts.Remote, strings.Join(opts.Masters, ", "))
+		for _, branch := range merged {
+			fmt.Printf("  %s (was %s)\n", describeBranch(branch.MergedBranch, opts.Masters), branch.Upstream)
+		}
+	}
+
+	if len(unpushed) > 0 {
+		fmt.Printf("\nThese branches track a branch gone from %s but have commits that are not merged:\n", opts.Remote)
+		for _, branch := range unpushed {
+			fmt.Printf("  %s (was %s, %d unmerged commits)\n", branch.Name, branch.Upstream, branch.Unpushed)
+		}
+	}
+
+	printCheckedOut(checkedOut)
+
+	var toDelete []hlpr.GoneBranch
+	if len(merged) > 0 {
+		if force || confirm("Delete the merged branches?") {
+			toDelete = append(toDelete, merged...)
+		}
+	}
+	if len(unpushed) > 0 {
+		if force {
+			fmt.Printf("\nLeaving the branches with unmerged commits, run without --force to be asked about them\n")
+		} else if confirm("Delete the branches with unmerged commits? Their commits will be lost.") {
+			toDelete = append(toDelete, unpushed...)
 		}
-		if !confirmDeleteBranches {
+	}
+
+	if len(toDelete) == 0 {
+		if !force && len(merged)+len(unpushed) > 0 {
 			fmt.Printf("OK, aborting.\n")
-			return
 		}
+		return
+	}
+
+	recorder := newDeletionRecorder(repo)
+	fmt.Printf("\n")
+
+	for _, branch := range toDelete {
+		if recorder.err != nil {
+			break
+		}
+		fmt.Printf("  deleting %s", branch.Name)
+		if err = hlpr.DeleteLocalBranch(repo, branch.Short); err != nil {
+			fmt.Printf(" - (failed: %s)\n", err)
+		} else {
+			fmt.Printf(" - (done)\n")
+			_ = recorder.record([]hlpr.BranchInfo{branch.BranchInfo})
+		}
+	}
+
+	exitOnFailures(nil, recorder.finish())
+}
+
+func handleRestore(run string, names []string, force bool) {
+	repo, err := hlpr.GetCurrentDirAsGitRepo()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: This is not a Git repository\n")
+		os.Exit(exitFailure)
+	}
+
+	entries, err := hlpr.ReadJournal(repo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when reading the journal: %s\n", err)
+		os.Exit(exitFailure)
+	}
+
+	run, entries, err = hlpr.SelectJournalRun(entries, run, names)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when reading the journal: %s\n", err)
+		os.Exit(exitFailure)
+	}
+
+	fmt.Printf("These branches were deleted in run %s:\n", run)
+	for _, entry := range entries {
+		fmt.Printf("  %s (%s)\n", entry.Name(), entry.ShortHash())
+	}
+
+	if !force && !confirm("Restore these branches?") {
+		fmt.Printf("OK, aborting.\n")
+		return
 	}
 
 	fmt.Printf("\n")
 
-	// Process deletions with progress indication for large sets
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	errs := hlpr.RestoreBranches(ctx, repo, entries)
+	stop()
+
+	for i, entry := range entries {
+		fmt.Printf("  restoring %s", entry.Name())
+		if errs[i] != nil {
+			fmt.Printf(" - (failed: %s)\n", errs[i])
+		} else {
+			fmt.Printf(" - (done)\n")
+		}
+	}
+}
+
+// deletionRecorder records deleted branches in the journal as they are deleted.
+type deletionRecorder struct {
+	journal  *hlpr.Journal
+	recorded int
+	err      error
+}
+
+// newDeletionRecorder opens the journal of repo for recording, exiting on
+// failure so that nothing is deleted without being recorded.
+func newDeletionRecorder(repo *git.Repository) *deletionRecorder {
+	journal, err := hlpr.OpenJournal(repo)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when opening the journal: %s\n", err)
+		os.Exit(exitFailure)
+	}
+	return &deletionRecorder{journal: journal}
+}
+
+// record appends the deleted branches to the journal, warning about any that
+// could not be recorded with the hash to restore it at. Once one fails, it
+// returns an error so that no more branches are deleted.
+func (r *deletionRecorder) record(deleted []hlpr.BranchInfo) error {
+	for _, branch := range deleted {
+		if err := r.journal.Record(branch); err != nil {
+			hlpr.LogWarnf("Could not record the deletion of %s (%s): %s", branch.Name, branch.Hash, err)
+			if r.err == nil {
+				r.err = fmt.Errorf("the journal could not record every deletion: %w", err)
+			}
+			continue
+		}
+		r.recorded++
+	}
+	return r.err
+}
+
+// finish closes the journal and tells how to restore the recorded branches. It
+// returns the error that stopped the recording, in which case no restore is
+// suggested, as it would miss branches.
+func (r *deletionRecorder) finish() error {
+	if err := r.journal.Close(); err != nil && r.err == nil {
+		r.err = err
+	}
+	if r.err != nil {
+		return r.err
+	}
+
+	if r.recorded > 0 {
+		fmt.Fprintf(progress, "\nTo restore the deleted branches, run `gitsweeper restore --run %s`\n", r.journal.Run())
+	}
+	return nil
+}
+
+// deleteMergedBranches deletes the branches, archiving them first if asked to,
+// records each batch in the journal as soon as it is deleted, and reports the
+// outcome of each branch in order. It returns the outcomes along with the error
+// that stopped the journal from recording them, which also stops the deletions.
+func deleteMergedBranches(
+	repo *git.Repository,
+	opts hlpr.MergedBranchesOptions,
+	mergedBranches []hlpr.MergedBranch,
+	deleteOpts hlpr.DeleteOptions,
+) ([]error, error) {
+	recorder := newDeletionRecorder(repo)
+	deleteOpts.Deleted = recorder.record
+	fmt.Fprintf(progress, "\n")
+
+	// Remote branches are deleted in batches, local ones one at a time
+	var errs []error
+	if opts.Local {
+		for _, branch := range mergedBranches {
+			err := recorder.err
+			if err != nil {
+				err = fmt.Errorf("not deleting branch %s: %w", branch.Short, err)
+			} else if deleteOpts.ArchivePrefix != "" {
+				err = hlpr.ArchiveLocalBranch(repo, branch.BranchInfo, deleteOpts.ArchivePrefix)
+			}
+			if err == nil {
+				if err = hlpr.DeleteLocalBranch(repo, branch.Short); err == nil {
+					_ = recorder.record([]hlpr.BranchInfo{branch.BranchInfo})
+				}
+			}
+			errs = append(errs, err)
+		}
+	} else {
+		branches := make([]hlpr.BranchInfo, len(mergedBranches))
+		for i, branch := range mergedBranches {
+			branches[i] = branch.BranchInfo
+		}
+
+		// Stop starting new pushes on Ctrl-C, letting the running ones finish
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		errs = hlpr.DeleteBranches(ctx, repo, opts.Remote, branches, deleteOpts)
+		stop()
+	}
+
+	// Report deletions in order, with progress indication for large sets
+	verb := "deleting"
+	if deleteOpts.ArchivePrefix != "" {
+		verb = "archiving"
+	}
+
 	total := len(mergedBranches)
-	for i, branchName := range mergedBranches {
-		remote, branchShort := hlpr.ParseBranchName(branchName)
+	moved, failed := 0, 0
+	for i, branch := range mergedBranches {
 		if total > 10 {
-			fmt.Printf("  [%d/%d] deleting %s", i+1, total, branchName)
+			fmt.Fprintf(progress, "  [%d/%d] %s %s", i+1, total, verb, branch.Name)
 		} else {
-			fmt.Printf("  deleting %s", branchName)
+			fmt.Fprintf(progress, "  %s %s", verb, branch.Name)
 		}
 
-		err := hlpr.DeleteBranch(repo, remote, branchShort)
-		if err != nil {
-			fmt.Printf(" - (failed: %s)\n", err)
+		switch {
+		case errors.Is(errs[i], hlpr.ErrBranchMoved):
+			moved++
+			fmt.Fprintf(progress, " - (skipped: branch moved)\n")
+		case errs[i] != nil:
+			failed++
+			fmt.Fprintf(progress, " - (failed: %s)\n", errs[i])
+		default:
+			fmt.Fprintf(progress, " - (done)\n")
+		}
+	}
+
+	if moved > 0 || failed > 0 {
+		fmt.Fprintf(progress, "\n")
+	}
+	if moved > 0 {
+		fmt.Fprintf(progress, "%d of %d branches were skipped as they moved since they were listed\n", moved, total)
+	}
+	if failed > 0 {
+		fmt.Fprintf(progress, "%d of %d branches could not be deleted\n", failed, total)
+	}
+
+	return errs, recorder.finish()
+}
+
+// deletionResult returns the record result of a branch deleteMergedBranches
+// returned err for.
+func deletionResult(err error, deleteOpts hlpr.DeleteOptions) string {
+	switch {
+	case errors.Is(err, hlpr.ErrBranchMoved):
+		return hlpr.ResultSkipped
+	case err != nil:
+		return hlpr.ResultFailed
+	case deleteOpts.ArchivePrefix != "":
+		return hlpr.ResultArchived
+	}
+	return hlpr.ResultDeleted
+}
+
+// exitOnFailures exits with exitFailure when the journal could not record the
+// deletions, and otherwise with exitPartial when any branch could not be
+// deleted. Branches skipped as they moved are not failures.
+func exitOnFailures(errs []error, journalErr error) {
+	if journalErr != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s, so the branches deleted cannot all be restored\n", journalErr)
+		os.Exit(exitFailure)
+	}
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, hlpr.ErrBranchMoved) {
+			os.Exit(exitPartial)
+		}
+	}
+}
+
+// keptOutcomes returns the outcomes of the branches kept as they are checked out.
+func keptOutcomes(branches []hlpr.MergedBranch) []outcome {
+	outcomes := make([]outcome, 0, len(branches))
+	for _, branch := range branches {
+		outcomes = append(outcomes, outcome{branch: branch, result: hlpr.ResultKept})
+	}
+	return outcomes
+}
+
+// writeOutcomes writes the outcomes to standard output, as records or with the
+// template, exiting on failure.
+func writeOutcomes(repo *git.Repository, out output, outcomes []outcome) {
+	var err error
+	if out.template != nil {
+		branches := make([]hlpr.BranchDetails, 0, len(outcomes))
+		for _, o := range outcomes {
+			var details hlpr.BranchDetails
+			if details, err = hlpr.GetBranchDetails(repo, o.branch); err != nil {
+				break
+			}
+
+			record := hlpr.NewBranchRecord(o.branch, o.result, o.err)
+			details.Result, details.Error = record.Result, record.Error
+			branches = append(branches, details)
+		}
+		if err == nil {
+			err = hlpr.WriteFormatted(os.Stdout, out.template, branches)
+		}
+	} else {
+		records := make([]hlpr.BranchRecord, 0, len(outcomes))
+		for _, o := range outcomes {
+			records = append(records, hlpr.NewBranchRecord(o.branch, o.result, o.err))
+		}
+		err = hlpr.WriteRecords(os.Stdout, out.format, records)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error when writing the output: %s\n", err)
+		os.Exit(exitFailure)
+	}
+}
+
+// splitCheckedOut separates the branches checked out in a worktree, which must
+// be kept, from the ones that may be deleted.
+func splitCheckedOut(branches []hlpr.MergedBranch) (deletable, checkedOut []hlpr.MergedBranch) {
+	for _, branch := range branches {
+		if branch.CheckedOutIn != "" {
+			checkedOut = append(checkedOut, branch)
 		} else {
-			fmt.Printf(" - (done)\n")
+			deletable = append(deletable, branch)
+		}
+	}
+	return deletable, checkedOut
+}
+
+// printCheckedOut explains why branches checked out in a worktree are kept.
+func printCheckedOut(branches []hlpr.MergedBranch) {
+	if len(branches) == 0 {
+		return
+	}
+
+	fmt.Printf("\nThese branches are checked out in a worktree, so will be kept:\n")
+	for _, branch := range branches {
+		fmt.Printf("  %s (in %s)\n", branch.Name, branch.CheckedOutIn)
+	}
+}
+
+// confirm asks the question on standard input, exiting when no answer can be read.
+func confirm(question string) bool {
+	answer, err := hlpr.AskForConfirmation(question, stdin)
+	if err != nil {
+		hlpr.LogFatalError("\nError when awaiting input", err)
+	}
+	return answer
+}
+
+// printBranchDetails lists the branches in a table along with the author, date,
+// age and subject of their head commits.
+func printBranchDetails(repo *git.Repository, branches []hlpr.MergedBranch, masters []string) {
+	table := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	for _, branch := range branches {
+		details, err := hlpr.GetBranchDetails(repo, branch)
+		if err != nil {
+			hlpr.LogWarnf("%s", err)
+			fmt.Fprintf(table, "  %s\t\t\t\t\n", describeBranch(branch, masters))
+			continue
 		}
+
+		fmt.Fprintf(table, "  %s\t%s\t%s\t%s\t%s\n", describeBranch(branch, masters), details.Author,
+			details.Date.Local().Format("2006-01-02"), details.Age(), truncate(details.Subject, 50))
+	}
+
+	if err := table.Flush(); err != nil {
+		hlpr.LogWarnf("Could not write the branches: %s", err)
+	}
+}
+
+// truncate shortens s to at most n characters, ending it with an ellipsis when
+// anything was cut.
+func truncate(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+// describeBranch returns the branch name, noting the detection strategy when the
+// branch head itself is not part of master, and which masters contain the branch
+// when more than one was asked for.
+func describeBranch(branch hlpr.MergedBranch, masters []string) string {
+	var notes []string
+	if branch.Strategy != hlpr.StrategyHash {
+		notes = append(notes, fmt.Sprintf("%s-merged", branch.Strategy))
+	}
+	if len(masters) > 1 || len(masters) == 1 && strings.ContainsAny(masters[0], "*?[") {
+		notes = append(notes, "in "+strings.Join(branch.Masters, ", "))
+	}
+
+	if len(notes) == 0 {
+		return branch.Name
+	}
+	return fmt.Sprintf("%s (%s)", branch.Name, strings.Join(notes, "; "))
+}
+
+// branchKind describes the branches being cleaned up, for messages.
+func branchKind(opts hlpr.MergedBranchesOptions) string {
+	if opts.Local {
+		return "local"
 	}
+	return "remote"
 }